@@ -27,7 +27,7 @@ var albumCmd = &cobra.Command{
 			return errors.Wrapf(err, "new client")
 		}
 
-		cl, err := photoslibrary.New(client)
+		cl, err := internal.NewPhotosService(client)
 		if err != nil {
 			return err
 		}
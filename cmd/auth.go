@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/ttomsu/gphotobackup/internal"
+)
+
+func init() {
+	rootCmd.AddCommand(authCmd)
+	authCmd.AddCommand(authMigrateCmd)
+	authCmd.AddCommand(authStatusCmd)
+
+	authStatusCmd.Flags().Bool("json", false, "Print the status as JSON, for monitoring")
+
+	authMigrateCmd.Flags().String("to", "auto", "Secret store to move the token into: auto, keyring or file")
+}
+
+var authCmd = &cobra.Command{
+	Use:   "auth",
+	Short: "Manage saved credentials",
+}
+
+var authStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Check that the saved credentials can refresh and reach the Photos Library API",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		status := internal.CheckAuth(context.Background())
+
+		if asJSON, _ := cmd.Flags().GetBool("json"); asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(status); err != nil {
+				return err
+			}
+		} else {
+			printAuthStatus(status)
+		}
+
+		switch {
+		case status.OK():
+			return nil
+		case status.LoginRequired():
+			return internal.ErrLoginRequired
+		default:
+			return errors.New("credentials check failed")
+		}
+	},
+}
+
+func printAuthStatus(s *internal.AuthStatus) {
+	fmt.Printf("Profile:      %v\n", s.Profile)
+	if s.SecretStore != "" {
+		fmt.Printf("Token store:  %v\n", s.SecretStore)
+	}
+	if !s.TokenExpiry.IsZero() {
+		fmt.Printf("Token expiry: %v\n", s.TokenExpiry.Local().Format(time.RFC1123))
+	}
+	if len(s.Scopes) > 0 {
+		fmt.Printf("Scopes:       %v\n", strings.Join(s.Scopes, " "))
+	}
+	for _, c := range []struct {
+		name  string
+		check internal.Check
+	}{
+		{"OAuth client", s.OAuthClient},
+		{"Token", s.Token},
+		{"Refresh", s.Refresh},
+		{"Scope", s.Scope},
+		{"Photos API", s.API},
+	} {
+		switch {
+		case c.check.OK:
+			fmt.Printf("[ok]   %v\n", c.name)
+		case c.check.Skipped:
+			fmt.Printf("[skip] %v\n", c.name)
+		default:
+			fmt.Printf("[FAIL] %v: %v\n       %v\n", c.name, c.check.Error, c.check.Hint)
+		}
+	}
+}
+
+var authMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Move a plaintext token.json into the OS keyring or a passphrase-encrypted file",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := NewLogger()
+		to, _ := cmd.Flags().GetString("to")
+		if to == "plain" {
+			return errors.New("--to must be auto, keyring or file")
+		}
+		viper.Set("secrets.backend", to)
+
+		store, err := internal.OpenSecretStore()
+		if err != nil {
+			return err
+		}
+		if store.Name() == "plain" {
+			return errors.Errorf("no keyring available; set $%v or secrets.passphraseFile to use an encrypted file", internal.PassphraseEnvVar)
+		}
+		if err := internal.MigrateToken(store); err != nil {
+			return errors.Wrap(err, "migrating token")
+		}
+		logger.Infof("Moved token.json to the %v secret store", store.Name())
+		if store.Name() == "file" {
+			logger.Infof("Later runs need the same passphrase in $%v or secrets.passphraseFile", internal.PassphraseEnvVar)
+		}
+		return nil
+	},
+}
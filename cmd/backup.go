@@ -1,6 +1,12 @@
 package cmd
 
 import (
+	"context"
+	stderrors "errors"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gphotosuploader/googlemirror/api/photoslibrary/v1"
@@ -9,6 +15,14 @@ import (
 	"github.com/spf13/viper"
 	"github.com/ttomsu/gphotobackup/internal"
 	"github.com/ttomsu/gphotobackup/internal/backup"
+	"github.com/ttomsu/gphotobackup/internal/lock"
+	"github.com/ttomsu/gphotobackup/internal/metrics"
+	"github.com/ttomsu/gphotobackup/internal/notify"
+	"github.com/ttomsu/gphotobackup/internal/quota"
+	"github.com/ttomsu/gphotobackup/internal/storage"
+	"github.com/ttomsu/gphotobackup/internal/throttle"
+	"github.com/ttomsu/gphotobackup/internal/utils"
+	"go.uber.org/zap"
 )
 
 func init() {
@@ -20,8 +34,25 @@ func init() {
 	backupCmd.PersistentFlags().Int("sinceDays", 0, "")
 	backupCmd.PersistentFlags().String("start", "", "")
 	backupCmd.PersistentFlags().String("end", "", "")
-	backupCmd.PersistentFlags().Int("workers", 3, "Concurrent download workers")
-	backupCmd.PersistentFlags().Bool("verbose", true, "Emit details of all media items")
+	backupCmd.PersistentFlags().String("workers", "3", "Concurrent download workers, or auto to adjust to the connection")
+	backupCmd.PersistentFlags().Int("workers-min", 1, "Fewest workers with --workers=auto")
+	backupCmd.PersistentFlags().Int("workers-max", 16, "Most workers with --workers=auto")
+	backupCmd.PersistentFlags().Bool("verbose", false, "Emit details of all media items")
+	checkError(backupCmd.PersistentFlags().MarkDeprecated("verbose", "use --log-level=debug instead"))
+	backupCmd.PersistentFlags().StringSlice("encrypt-recipient", nil, "Encrypt with age for this recipient (age1...) or recipients file")
+	backupCmd.PersistentFlags().String("encrypt-passphrase-file", "", "Encrypt with age using the passphrase in this file")
+	backupCmd.PersistentFlags().Bool("dedup", false, "Store identical files once, linking to them from the date and album trees")
+	backupCmd.PersistentFlags().Bool("obfuscate-names", false, "Name files by media item ID, with metadata in an encrypted sidecar")
+	backupCmd.PersistentFlags().Bool("all-profiles", false, "Back up every profile in turn, each into its own subtree of --out")
+	backupCmd.PersistentFlags().String("metrics-textfile", "", "Write Prometheus metrics to this file for node_exporter's textfile collector when done")
+	backupCmd.PersistentFlags().String("max-bandwidth", "", "Limit downloads to this rate across all workers, e.g. 2MB/s")
+	backupCmd.PersistentFlags().String("bandwidth-schedule", "", "Daily download rates, e.g. \"00:00-07:00=unlimited, 07:00-24:00=2MB/s\"; --max-bandwidth applies outside it")
+	backupCmd.PersistentFlags().Duration("wait", 0, "If another backup is writing to --out, wait this long for it to finish")
+	backupCmd.PersistentFlags().Bool("dry-run", false, "Report what would be downloaded without downloading or writing anything")
+	backupCmd.PersistentFlags().Bool("dry-run-sizes", false, "With --dry-run, ask for the size of each new item (uses media quota)")
+	backupCmd.PersistentFlags().Bool("json", false, "With --dry-run, print the report as JSON")
+	backupCmd.PersistentFlags().String("progress", "auto", "Progress display: auto, tty, log or off. auto draws on a terminal and logs otherwise")
+	backupCmd.PersistentFlags().Duration("progress-interval", 30*time.Second, "How often to log progress when not drawing on a terminal")
 
 	checkError(viper.BindPFlags(backupCmd.PersistentFlags()))
 }
@@ -30,95 +61,359 @@ var backupCmd = &cobra.Command{
 	Use:   "backup",
 	Short: "Download all photos/videos found in the specified album or date range",
 	RunE: func(cmd *cobra.Command, args []string) error {
-		logger := NewLogger()
-		client, err := internal.NewClient()
-		if err != nil {
-			return errors.Wrapf(err, "new client")
+		if viper.GetBool("dry-run") {
+			return forEachProfile(dryRun)
+		}
+		err := backupProfiles(cmd.Context())
+		if errors.Is(err, quota.ErrExhausted) {
+			NewLogger().Warnf("Stopped early: %v. Run again after %v to continue.", err, quotaResetAt().Local().Format(time.RFC1123))
+			return nil
 		}
+		return err
+	},
+}
 
-		bs, err := backup.NewSession(client, viper.GetString("out"), viper.GetInt("workers"), logger)
-		if err != nil {
-			return errors.Wrapf(err, "new session")
+// backupProfiles runs the backup for the selected profile, or for each
+// profile in turn with --all-profiles, and then records the outcome in the
+// metrics and sends notifications. A run stopped by the daily quota returns
+// quota.ErrExhausted, and counts as neither failed nor successful. Cancelling
+// ctx stops the run after the items being downloaded are done or abandoned.
+func backupProfiles(ctx context.Context) error {
+	stateDir := internal.StateDir()
+	metrics.LoadLastSuccess(stateDir)
+
+	start := time.Now()
+	total := &backup.Stats{}
+	err := backupEachProfile(ctx, total)
+	notifyDone(start, total, err)
+	if err == nil {
+		if serr := metrics.RecordSuccess(stateDir); serr != nil {
+			NewLogger().Warnf("Recording success: %v", serr)
 		}
+	}
+	if textfile := viper.GetString("metrics-textfile"); textfile != "" {
+		if terr := metrics.WriteTextfile(textfile); terr != nil {
+			NewLogger().Errorf("%v", terr)
+		}
+	}
+	return err
+}
 
-		searchReq := &photoslibrary.SearchMediaItemsRequest{
-			PageSize: 100,
+// notifyDone sends the run summary to the sinks configured under notify.
+func notifyDone(start time.Time, total *backup.Stats, err error) {
+	logger := NewLogger()
+	n, nerr := notify.FromConfig()
+	if nerr != nil {
+		logger.Errorf("%v", nerr)
+		return
+	} else if n == nil {
+		return
+	}
+
+	profile := viper.GetString("profile")
+	if viper.GetBool("all-profiles") {
+		profile = "all"
+	} else if profile == "" {
+		profile = internal.DefaultProfile
+	}
+	summary := &notify.Summary{
+		Profile:    profile,
+		Start:      start,
+		Duration:   time.Since(start).Round(time.Second),
+		Downloaded: total.Downloaded.Load(),
+		Skipped:    total.Skipped.Load(),
+		Failed:     total.Failed.Load(),
+		Bytes:      total.Bytes.Load(),
+		Success:    err == nil && total.Failed.Load() == 0,
+		Deferred:   errors.Is(err, quota.ErrExhausted),
+		Stopped:    errors.Is(err, context.Canceled),
+	}
+	if err != nil && !summary.Deferred && !summary.Stopped {
+		summary.Error = err.Error()
+	}
+	if nerr := n.Notify(context.Background(), summary); nerr != nil {
+		logger.Errorf("%v", nerr)
+	}
+}
+
+func backupEachProfile(ctx context.Context, total *backup.Stats) error {
+	return forEachProfile(func(profile string) error {
+		if err := ctx.Err(); err != nil {
+			return err
 		}
-		switch {
-		case viper.GetString("albumID") != "":
-			searchReq.AlbumId = viper.GetString("albumID")
-		case viper.GetDuration("sinceDays") != 0:
-			durDays := viper.GetDuration("sinceDays")
-			ey, em, ed := time.Now().Date()
-			sy, sm, sd := time.Now().Add(-1 * 24 * time.Hour * durDays).Date()
-			searchReq.Filters = &photoslibrary.Filters{
-				IncludeArchivedMedia: true,
-				DateFilter: &photoslibrary.DateFilter{
-					Ranges: []*photoslibrary.DateRange{
-						{
-							StartDate: &photoslibrary.Date{
-								Day:   int64(sd),
-								Month: int64(sm),
-								Year:  int64(sy),
-							},
-							EndDate: &photoslibrary.Date{
-								Day:   int64(ed),
-								Month: int64(em),
-								Year:  int64(ey),
-							},
-						},
-					},
-				},
-			}
-		case viper.GetString("start") != "":
-			start, err := time.Parse("2006-01-02", viper.GetString("start"))
-			if err != nil {
-				return errors.Wrap(err, "invalid --start")
-			}
-			sy, sm, sd := start.Date()
+		return runBackup(ctx, profile, total)
+	})
+}
 
-			ey, em, ed := time.Now().Date()
-			if toStr := viper.GetString("end"); toStr != "" {
-				if end, err := time.Parse("2006-01-02", toStr); err != nil {
-					return errors.Wrap(err, "invalid --end")
-				} else {
-					ey, em, ed = end.Date()
-				}
+// forEachProfile calls run for the selected profile, or with --all-profiles
+// for each profile in turn, carrying on past failures.
+func forEachProfile(run func(profile string) error) error {
+	if !viper.GetBool("all-profiles") {
+		return run(viper.GetString("profile"))
+	}
 
+	profiles, err := internal.Profiles()
+	if err != nil {
+		return err
+	}
+	if len(profiles) == 0 {
+		return errors.New("no profiles found, run 'gphotobackup login --profile <name>' first")
+	}
+	var errs []error
+	for _, p := range profiles {
+		if err := internal.SetProfile(p); err != nil {
+			return err
+		}
+		if err := run(p); err != nil {
+			NewLogger().Errorf("Profile %v failed: %v", p, err)
+			errs = append(errs, errors.Wrapf(err, "profile %v", p))
+		}
+	}
+	return joinProfileErrors(errs)
+}
+
+// joinProfileErrors combines the errors of several profiles, so that
+// errors.Is still finds ErrLoginRequired in any of them. A run is only
+// deferred or stopped when every profile was stopped by the quota or
+// cancelled, so if others failed, quota.ErrExhausted and context.Canceled are
+// kept out of the result.
+func joinProfileErrors(errs []error) error {
+	paused := 0
+	for _, err := range errs {
+		if notFailed(err) {
+			paused++
+		}
+	}
+	if paused > 0 && paused < len(errs) {
+		for i, err := range errs {
+			if notFailed(err) {
+				errs[i] = errors.New(err.Error())
 			}
-			searchReq.Filters = &photoslibrary.Filters{
-				IncludeArchivedMedia: true,
-				DateFilter: &photoslibrary.DateFilter{
-					Ranges: []*photoslibrary.DateRange{
-						{
-							StartDate: &photoslibrary.Date{
-								Day:   int64(sd),
-								Month: int64(sm),
-								Year:  int64(sy),
-							},
-							EndDate: &photoslibrary.Date{
-								Day:   int64(ed),
-								Month: int64(em),
-								Year:  int64(ey),
-							},
-						},
-					},
-				},
-			}
-		default:
-			return errors.New("Must specify either --albumID, --sinceDays or --start[/--end]")
 		}
+	}
+	return stderrors.Join(errs...)
+}
 
-		bs.Start(searchReq)
+// notFailed is true for runs stopped by the quota or cancelled.
+func notFailed(err error) bool {
+	return errors.Is(err, quota.ErrExhausted) || errors.Is(err, context.Canceled)
+}
 
-		if viper.GetBool("favorites") {
-			bs.StartFavorites()
+// runBackup backs up a single profile, whose config dir must already be
+// selected, adding its counts to total.
+func runBackup(ctx context.Context, profile string, total *backup.Stats) error {
+	logger := NewLogger()
+	if profile != "" {
+		logger = logger.With("profile", profile)
+	}
+	client, err := internal.NewClient()
+	if err != nil {
+		return errors.Wrapf(err, "new client")
+	}
+	defer func() {
+		if err := internal.Quota().Save(); err != nil {
+			logger.Warnf("%v", err)
 		}
+	}()
+
+	out := profileOut(viper.GetString("out"), profile)
+	store, err := openOut(out)
+	if err != nil {
+		return err
+	}
 
-		if viper.GetBool("albums") {
-			bs.StartAlbums()
+	runLock, err := lock.Acquire(lockPath(out), viper.GetDuration("wait"), 10*time.Second)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := runLock.Release(); err != nil {
+			logger.Warnf("Releasing lock: %v", err)
 		}
+	}()
+	if runLock.Stale != nil {
+		logger.Warnf("The previous backup (%v) did not finish, resuming", runLock.Stale)
+	}
 
-		return nil
-	},
+	bs, err := newSession(client, store, logger)
+	if err != nil {
+		return err
+	}
+	bs.SetContext(ctx)
+	defer total.Add(bs.Stats())
+	stopProgress, err := startProgress(bs, logger)
+	if err != nil {
+		return err
+	}
+	defer stopProgress()
+	if limiter, err := bandwidthLimiter(); err != nil {
+		return err
+	} else if limiter != nil {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go limiter.Run(ctx)
+		bs.SetLimiter(limiter)
+	}
+
+	if err := runPhases(bs); err != nil {
+		return err
+	}
+
+	if viper.GetBool("dedup") {
+		bs.ReportDedup()
+	}
+
+	return nil
+}
+
+// openOut opens the destination, encrypting it if asked to.
+func openOut(out string) (storage.Storage, error) {
+	store, err := storage.Open(out)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening --out")
+	}
+	recipients, err := encryptionRecipients(store)
+	if err != nil {
+		return nil, err
+	}
+	if len(recipients) > 0 {
+		store = storage.NewEncrypted(store, recipients, nil)
+	} else if viper.GetBool("obfuscate-names") {
+		return nil, errors.New("--obfuscate-names requires --encrypt-recipient or --encrypt-passphrase-file")
+	}
+	if viper.GetBool("dedup") && !storage.HardLinks(store) {
+		return nil, errors.New("--dedup needs a local or SFTP destination; other destinations have no hard links, so it would save nothing")
+	}
+	return store, nil
+}
+
+// newSession creates a session with the --workers setting.
+func newSession(client *http.Client, store storage.Storage, logger *zap.SugaredLogger) (*backup.Session, error) {
+	workers := viper.GetString("workers")
+	workerCount, err := strconv.Atoi(workers)
+	if workers != "auto" && (err != nil || workerCount < 1) {
+		return nil, errors.Errorf("--workers must be a positive number or auto, not %q", workers)
+	}
+	bs, err := backup.NewSession(client, store, workerCount, logger)
+	if err != nil {
+		return nil, errors.Wrapf(err, "new session")
+	}
+	if workers == "auto" {
+		bs.AutoScale(viper.GetInt("workers-min"), viper.GetInt("workers-max"))
+	}
+	return bs, nil
+}
+
+// runPhases backs up the search results, then favorites and albums if asked
+// to.
+func runPhases(bs *backup.Session) error {
+	searchReq, err := searchRequest()
+	if err != nil {
+		return err
+	}
+
+	if err := bs.Start(searchReq); err != nil {
+		return errors.Wrap(err, "backup")
+	}
+
+	if viper.GetBool("favorites") {
+		if err := bs.StartFavorites(); err != nil {
+			return errors.Wrap(err, "favorites")
+		}
+	}
+
+	if viper.GetBool("albums") {
+		if err := bs.StartAlbums(); err != nil {
+			return errors.Wrap(err, "albums")
+		}
+	}
+	return nil
+}
+
+// quotaResetAt is when the API quota resets, or now if nothing is tracked.
+func quotaResetAt() time.Time {
+	if q := internal.Quota(); q != nil {
+		return q.ResetAt()
+	}
+	return time.Now()
+}
+
+// bandwidthLimiter returns the limiter for --max-bandwidth and
+// --bandwidth-schedule, or nil if neither is set.
+func bandwidthLimiter() (*throttle.Limiter, error) {
+	maxRate, err := throttle.ParseRate(viper.GetString("max-bandwidth"))
+	if err != nil {
+		return nil, errors.Wrap(err, "--max-bandwidth")
+	}
+	schedule, err := throttle.ParseSchedule(viper.GetString("bandwidth-schedule"))
+	if err != nil {
+		return nil, errors.Wrap(err, "--bandwidth-schedule")
+	}
+	if maxRate == throttle.Unlimited && len(schedule) == 0 {
+		return nil, nil
+	}
+	return throttle.NewLimiter(maxRate, schedule), nil
+}
+
+// lockPath is the lock file for a destination: inside it when it's a local
+// dir, otherwise in the state dir.
+func lockPath(out string) string {
+	if !strings.Contains(out, "://") {
+		return filepath.Join(out, ".gphotobackup.lock")
+	}
+	return filepath.Join(internal.StateDir(), "locks", utils.Sanitize(out)+".lock")
+}
+
+// searchRequest builds the search for --albumID, --sinceDays or --start/--end.
+func searchRequest() (*photoslibrary.SearchMediaItemsRequest, error) {
+	searchReq := &photoslibrary.SearchMediaItemsRequest{
+		PageSize: 100,
+	}
+	switch {
+	case viper.GetString("albumID") != "":
+		searchReq.AlbumId = viper.GetString("albumID")
+	case viper.GetDuration("sinceDays") != 0:
+		durDays := viper.GetDuration("sinceDays")
+		searchReq.Filters = dateRangeFilters(time.Now().Add(-1*24*time.Hour*durDays), time.Now())
+	case viper.GetString("start") != "":
+		start, err := time.Parse("2006-01-02", viper.GetString("start"))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid --start")
+		}
+
+		end := time.Now()
+		if toStr := viper.GetString("end"); toStr != "" {
+			if end, err = time.Parse("2006-01-02", toStr); err != nil {
+				return nil, errors.Wrap(err, "invalid --end")
+			}
+		}
+		searchReq.Filters = dateRangeFilters(start, end)
+	default:
+		return nil, errors.New("Must specify either --albumID, --sinceDays or --start[/--end]")
+	}
+	return searchReq, nil
+}
+
+func dateRangeFilters(start, end time.Time) *photoslibrary.Filters {
+	sy, sm, sd := start.Date()
+	ey, em, ed := end.Date()
+	return &photoslibrary.Filters{
+		IncludeArchivedMedia: true,
+		DateFilter: &photoslibrary.DateFilter{
+			Ranges: []*photoslibrary.DateRange{
+				{
+					StartDate: &photoslibrary.Date{
+						Day:   int64(sd),
+						Month: int64(sm),
+						Year:  int64(sy),
+					},
+					EndDate: &photoslibrary.Date{
+						Day:   int64(ed),
+						Month: int64(em),
+						Year:  int64(ey),
+					},
+				},
+			},
+		},
+	}
 }
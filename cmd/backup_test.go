@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/ttomsu/gphotobackup/internal"
+	"github.com/ttomsu/gphotobackup/internal/quota"
+)
+
+func TestJoinProfileErrors(t *testing.T) {
+	if err := joinProfileErrors(nil); err != nil {
+		t.Errorf("no failures = %v, want nil", err)
+	}
+
+	err := joinProfileErrors([]error{
+		errors.Wrap(internal.ErrLoginRequired, "profile alice"),
+		errors.Wrap(quota.ErrExhausted, "profile bob"),
+	})
+	if !errors.Is(err, internal.ErrLoginRequired) {
+		t.Errorf("lost ErrLoginRequired: %v", err)
+	}
+	if errors.Is(err, quota.ErrExhausted) {
+		t.Errorf("a run that also failed counts as deferred: %v", err)
+	}
+
+	err = joinProfileErrors([]error{
+		errors.New("profile alice: boom"),
+		errors.Wrap(context.Canceled, "profile bob"),
+	})
+	if errors.Is(err, context.Canceled) {
+		t.Errorf("a run that also failed counts as stopped: %v", err)
+	}
+
+	err = joinProfileErrors([]error{
+		errors.Wrap(quota.ErrExhausted, "profile alice"),
+		errors.Wrap(quota.ErrExhausted, "profile bob"),
+	})
+	if !errors.Is(err, quota.ErrExhausted) {
+		t.Errorf("every profile stopped by the quota, but not deferred: %v", err)
+	}
+}
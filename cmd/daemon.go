@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/ttomsu/gphotobackup/internal/daemon"
+	"github.com/ttomsu/gphotobackup/internal/metrics"
+	"github.com/ttomsu/gphotobackup/internal/quota"
+	"go.uber.org/zap"
+)
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+
+	daemonCmd.Flags().String("schedule", "@daily", "Cron expression, @daily or @every <duration> for when to run")
+	daemonCmd.Flags().Duration("jitter", 15*time.Minute, "Delay each run by a random amount up to this")
+	daemonCmd.Flags().Duration("retry-min", 5*time.Minute, "First retry delay after a failed run, doubling on each failure")
+	daemonCmd.Flags().Duration("retry-max", 6*time.Hour, "Longest retry delay after failed runs")
+	daemonCmd.Flags().Int("since-days", 3, "Days of recent photos to back up on each run")
+	daemonCmd.Flags().String("metrics-addr", "", "Serve Prometheus metrics at /metrics on this address, e.g. :9090")
+
+	checkError(viper.BindPFlag("daemon.schedule", daemonCmd.Flags().Lookup("schedule")))
+	checkError(viper.BindPFlag("daemon.jitter", daemonCmd.Flags().Lookup("jitter")))
+	checkError(viper.BindPFlag("daemon.retryMin", daemonCmd.Flags().Lookup("retry-min")))
+	checkError(viper.BindPFlag("daemon.retryMax", daemonCmd.Flags().Lookup("retry-max")))
+	checkError(viper.BindPFlag("daemon.sinceDays", daemonCmd.Flags().Lookup("since-days")))
+	checkError(viper.BindPFlag("daemon.metricsAddr", daemonCmd.Flags().Lookup("metrics-addr")))
+	viper.SetDefault("daemon.favorites", true)
+	viper.SetDefault("daemon.albums", true)
+}
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run backups on a schedule until stopped. The config is reloaded on SIGHUP.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		logger := NewLogger()
+		sched, err := newDaemonScheduler()
+		if err != nil {
+			return err
+		}
+
+		if addr := viper.GetString("daemon.metricsAddr"); addr != "" {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", metrics.Handler())
+			server := &http.Server{Addr: addr, Handler: mux}
+			go func() {
+				if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+					logger.Errorf("Metrics server: %v", err)
+				}
+			}()
+			defer server.Close()
+			logger.Infof("Serving metrics on %v/metrics", addr)
+		}
+
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(signals)
+
+		var done chan error
+		stop := func() {}
+		next := sched.Next(time.Now())
+		logger.Infof("Next backup at %v", next.Format(time.RFC1123))
+		timer := time.NewTimer(time.Until(next))
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-timer.C:
+				// Runs are sequential: the timer isn't reset until this one is done.
+				done, stop = startDaemonRun(logger)
+
+			case err := <-done:
+				done = nil
+				stop()
+				exhausted := errors.Is(err, quota.ErrExhausted)
+				if exhausted {
+					// Not a failure: carry on once the quota resets.
+					sched.Done(nil)
+					logger.Warnf("Daily quota exhausted, continuing after it resets")
+				} else {
+					sched.Done(err)
+					if err != nil {
+						logger.Errorf("Backup failed (%v in a row): %v", sched.Failures(), err)
+					} else {
+						logger.Info("Backup finished")
+					}
+				}
+				next = sched.Next(time.Now())
+				if exhausted {
+					next = quotaResetAt().Add(time.Minute)
+				}
+				logger.Infof("Next backup at %v", next.Format(time.RFC1123))
+				timer.Reset(time.Until(next))
+
+			case sig := <-signals:
+				if sig != syscall.SIGHUP {
+					if done != nil {
+						// Let the run save the quota, release its lock and
+						// clean up its partial downloads before exiting.
+						logger.Warnf("Received %v during a backup, stopping", sig)
+						stop()
+						<-done
+					}
+					return nil
+				}
+				logger.Info("Received SIGHUP, reloading config")
+				var notFound viper.ConfigFileNotFoundError
+				if err := viper.ReadInConfig(); err != nil && !errors.As(err, &notFound) {
+					logger.Errorf("Reloading config: %v", err)
+					continue
+				}
+				reloaded, err := newDaemonScheduler()
+				if err != nil {
+					logger.Errorf("Keeping the previous schedule: %v", err)
+					continue
+				}
+				sched.Reload(reloaded)
+				if done == nil {
+					next = sched.Next(time.Now())
+					logger.Infof("Next backup at %v", next.Format(time.RFC1123))
+					timer.Reset(time.Until(next))
+				}
+			}
+		}
+	},
+}
+
+func newDaemonScheduler() (*daemon.Scheduler, error) {
+	return daemon.NewScheduler(
+		viper.GetString("daemon.schedule"),
+		viper.GetDuration("daemon.jitter"),
+		viper.GetDuration("daemon.retryMin"),
+		viper.GetDuration("daemon.retryMax"))
+}
+
+// startDaemonRun starts daemonRun in the background. Its result is sent on
+// done, and stop cancels it.
+func startDaemonRun(logger *zap.SugaredLogger) (done chan error, stop context.CancelFunc) {
+	ctx, stop := context.WithCancel(context.Background())
+	done = make(chan error, 1)
+	go func() { done <- daemonRun(ctx, logger) }()
+	return done, stop
+}
+
+// daemonRun backs up the recent photos, then favorites and albums, as
+// configured under daemon.* in the config.
+func daemonRun(ctx context.Context, logger *zap.SugaredLogger) error {
+	sinceDays := viper.GetInt("daemon.sinceDays")
+	if sinceDays <= 0 {
+		return errors.New("daemon.sinceDays must be positive")
+	}
+	viper.Set("albumID", "")
+	viper.Set("start", "")
+	viper.Set("sinceDays", sinceDays)
+	viper.Set("favorites", viper.GetBool("daemon.favorites"))
+	viper.Set("albums", viper.GetBool("daemon.albums"))
+	viper.Set("all-profiles", viper.GetBool("daemon.allProfiles"))
+
+	logger.Infof("Starting scheduled backup of the last %v days", sinceDays)
+	return backupProfiles(ctx)
+}
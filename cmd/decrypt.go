@@ -0,0 +1,241 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/gphotosuploader/googlemirror/api/photoslibrary/v1"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/ttomsu/gphotobackup/internal/backup"
+	"github.com/ttomsu/gphotobackup/internal/storage"
+)
+
+// passphraseEnv can hold the passphrase instead of --passphrase-file.
+const passphraseEnv = "GPHOTOBACKUP_PASSPHRASE"
+
+func init() {
+	rootCmd.AddCommand(decryptCmd)
+
+	decryptCmd.PersistentFlags().String("in", "", "Encrypted backup to read, as a directory or URL")
+	decryptCmd.PersistentFlags().StringSlice("identity", nil, "age identity file(s) to decrypt with")
+	decryptCmd.PersistentFlags().String("passphrase-file", "", "File holding the passphrase the backup was encrypted with")
+
+	checkError(viper.BindPFlags(decryptCmd.PersistentFlags()))
+}
+
+var decryptCmd = &cobra.Command{
+	Use:     "decrypt",
+	Aliases: []string{"restore"},
+	Short:   "Decrypt a backup made with --encrypt-recipient into --out, restoring the original filenames",
+	RunE: func(_ *cobra.Command, args []string) error {
+		logger := NewLogger()
+		if viper.GetString("in") == "" || viper.GetString("out") == "" {
+			return errors.New("--in and --out are required")
+		}
+		in, err := storage.Open(viper.GetString("in"))
+		if err != nil {
+			return errors.Wrap(err, "opening --in")
+		}
+		identities, err := decryptionIdentities(in)
+		if err != nil {
+			return err
+		}
+		src := storage.NewEncrypted(in, nil, identities)
+		dst, err := storage.Open(viper.GetString("out"))
+		if err != nil {
+			return errors.Wrap(err, "opening --out")
+		}
+
+		// The blobs of a --dedup backup are reached through the links in
+		// the date and album trees, and aren't restored themselves.
+		var sidecars []string
+		copied := make(map[string]bool)
+		total := 0
+		err = storage.Walk(src, ".", func(name string, fi *storage.FileInfo) error {
+			if strings.HasPrefix(name, backup.BlobDir+"/") {
+				return nil
+			}
+			if err := copyFile(src, dst, name, fi); err != nil {
+				return err
+			}
+			if strings.HasSuffix(name, backup.SidecarSuffix) {
+				sidecars = append(sidecars, name)
+			}
+			copied[name] = true
+			total++
+			logger.Debugf("Decrypted %v", name)
+			return nil
+		})
+		if err != nil {
+			return errors.Wrap(err, "decrypting")
+		}
+
+		for _, sidecar := range sidecars {
+			// Only a .json next to the item it describes is a sidecar.
+			if !copied[strings.TrimSuffix(sidecar, backup.SidecarSuffix)] {
+				continue
+			}
+			if err := restoreFilename(dst, sidecar); err != nil {
+				logger.Warnf("Could not restore filename for %v: %v", sidecar, err)
+			}
+		}
+		logger.Infof("Decrypted %v files", total)
+		return nil
+	},
+}
+
+func copyFile(src, dst storage.Storage, name string, fi *storage.FileInfo) error {
+	if err := dst.MkdirAll(path.Dir(name)); err != nil {
+		return errors.Wrapf(err, "creating dir for %v", name)
+	}
+	r, err := src.Open(name)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := dst.Create(name, fi.ModTime)
+	if err != nil {
+		return errors.Wrapf(err, "creating %v", name)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		_ = w.Abort()
+		return errors.Wrapf(err, "copying %v", name)
+	}
+	return errors.Wrapf(w.Close(), "committing %v", name)
+}
+
+// restoreFilename renames an item backed up with --obfuscate-names back to its
+// original filename, using the metadata in its sidecar.
+func restoreFilename(dst storage.Storage, sidecar string) error {
+	item := strings.TrimSuffix(sidecar, backup.SidecarSuffix)
+	if _, err := dst.Stat(item); err != nil {
+		return err
+	}
+	r, err := dst.Open(sidecar)
+	if err != nil {
+		return err
+	}
+	mi := &photoslibrary.MediaItem{}
+	err = json.NewDecoder(r).Decode(mi)
+	_ = r.Close()
+	if err != nil {
+		return errors.Wrap(err, "decoding sidecar")
+	} else if mi.Id == "" || mi.Filename == "" {
+		return errors.New("sidecar has no media item ID or filename")
+	}
+	if err := dst.Rename(item, path.Join(path.Dir(item), backup.Filename(mi))); err != nil {
+		return err
+	}
+	return dst.Remove(sidecar)
+}
+
+// encryptionRecipients returns the recipients from --encrypt-recipient or
+// --encrypt-passphrase-file, or nil if encryption is not enabled. With a
+// passphrase, files are encrypted to the key kept for it in store.
+func encryptionRecipients(store storage.Storage) ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	for _, r := range viper.GetStringSlice("encrypt-recipient") {
+		if strings.HasPrefix(r, "age1") {
+			recipient, err := age.ParseX25519Recipient(r)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid --encrypt-recipient %v", r)
+			}
+			recipients = append(recipients, recipient)
+			continue
+		}
+		f, err := os.Open(r)
+		if err != nil {
+			return nil, errors.Wrapf(err, "opening recipients file %v", r)
+		}
+		parsed, err := age.ParseRecipients(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing recipients file %v", r)
+		}
+		recipients = append(recipients, parsed...)
+	}
+
+	if file := viper.GetString("encrypt-passphrase-file"); file != "" {
+		passphrase, err := readPassphrase(file)
+		if err != nil {
+			return nil, err
+		}
+		if len(recipients) > 0 {
+			return nil, errors.New("a passphrase cannot be combined with other recipients")
+		}
+		// A dry run writes nothing, so it doesn't create the key.
+		key, err := storage.PassphraseKey(store, passphrase, !viper.GetBool("dry-run"))
+		if errors.Is(err, fs.ErrNotExist) {
+			recipient, err := age.NewScryptRecipient(passphrase)
+			if err != nil {
+				return nil, errors.Wrap(err, "passphrase recipient")
+			}
+			return []age.Recipient{recipient}, nil
+		} else if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, key.Recipient())
+	}
+	return recipients, nil
+}
+
+// decryptionIdentities returns the identities from --identity, or the
+// passphrase from --passphrase-file or $GPHOTOBACKUP_PASSPHRASE along with
+// the key it unlocks in store.
+func decryptionIdentities(store storage.Storage) ([]age.Identity, error) {
+	var identities []age.Identity
+	for _, file := range viper.GetStringSlice("identity") {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "opening identity file %v", file)
+		}
+		parsed, err := age.ParseIdentities(f)
+		_ = f.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing identity file %v", file)
+		}
+		identities = append(identities, parsed...)
+	}
+
+	passphrase := os.Getenv(passphraseEnv)
+	if file := viper.GetString("passphrase-file"); file != "" {
+		var err error
+		if passphrase, err = readPassphrase(file); err != nil {
+			return nil, err
+		}
+	}
+	if passphrase != "" {
+		key, err := storage.PassphraseKey(store, passphrase, false)
+		if err == nil {
+			identities = append(identities, key)
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		// Files written before the key was added are encrypted to the
+		// passphrase itself.
+		identity, err := age.NewScryptIdentity(passphrase)
+		if err != nil {
+			return nil, errors.Wrap(err, "passphrase identity")
+		}
+		identities = append(identities, identity)
+	}
+	if len(identities) == 0 {
+		return nil, errors.New("--identity or --passphrase-file required")
+	}
+	return identities, nil
+}
+
+func readPassphrase(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", errors.Wrapf(err, "reading passphrase file %v", file)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/ttomsu/gphotobackup/internal"
+	"github.com/ttomsu/gphotobackup/internal/backup"
+	"github.com/ttomsu/gphotobackup/internal/quota"
+	"github.com/ttomsu/gphotobackup/internal/utils"
+)
+
+// dryRunReport is the --dry-run output for one profile.
+type dryRunReport struct {
+	Profile string `json:"profile"`
+	Out     string `json:"out"`
+	*backup.Plan
+	// QuotaUsed is today's usage including the dry run's own searches.
+	QuotaUsed   quota.Usage  `json:"quotaUsed"`
+	QuotaLimits quota.Limits `json:"quotaLimits"`
+}
+
+// dryRun runs the same searches as a backup of one profile, but only
+// reports which items it would download.
+func dryRun(profile string) error {
+	logger := NewLogger()
+	if profile != "" {
+		logger = logger.With("profile", profile)
+	}
+	client, err := internal.NewClient()
+	if err != nil {
+		return errors.Wrapf(err, "new client")
+	}
+	defer func() {
+		if err := internal.Quota().Save(); err != nil {
+			logger.Warnf("%v", err)
+		}
+	}()
+
+	out := profileOut(viper.GetString("out"), profile)
+	store, err := openOut(out)
+	if err != nil {
+		return err
+	}
+	bs, err := newSession(client, store, logger)
+	if err != nil {
+		return err
+	}
+	plan := &backup.Plan{}
+	bs.SetDryRun(plan, viper.GetBool("dry-run-sizes"))
+	if err := runPhases(bs); err != nil {
+		return err
+	}
+
+	if profile == "" {
+		profile = internal.DefaultProfile
+	}
+	for _, items := range [][]backup.PlanItem{plan.New, plan.Present} {
+		sort.Slice(items, func(i, j int) bool { return items[i].Path < items[j].Path })
+	}
+	report := &dryRunReport{Profile: profile, Out: out, Plan: plan}
+	if q := internal.Quota(); q != nil {
+		report.QuotaUsed, report.QuotaLimits = q.Usage(), q.Limits()
+	}
+	if viper.GetBool("json") {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
+	}
+	printDryRun(os.Stdout, report)
+	return nil
+}
+
+func printDryRun(w io.Writer, r *dryRunReport) {
+	for _, item := range r.New {
+		switch {
+		case item.Note != "":
+			fmt.Fprintf(w, "new  %v (%v)\n", item.Path, item.Note)
+		case item.Bytes > 0:
+			fmt.Fprintf(w, "new  %v (%v)\n", item.Path, utils.FormatBytes(item.Bytes))
+		default:
+			fmt.Fprintf(w, "new  %v\n", item.Path)
+		}
+	}
+
+	size := utils.FormatBytes(r.Bytes)
+	if r.UnknownSize > 0 {
+		size = fmt.Sprintf("%v, plus %v items of unknown size", size, r.UnknownSize)
+	}
+	fmt.Fprintf(w, "Profile %v: %v new items (%v) would be downloaded to %v, %v already present\n",
+		r.Profile, len(r.New), size, r.Out, len(r.Present))
+
+	fmt.Fprintf(w, "Quota: %v API requests and %v downloads needed, %v and %v left today\n",
+		r.Cost.API, r.Cost.Media, quotaLeft(r.QuotaLimits.API, r.QuotaUsed.API), quotaLeft(r.QuotaLimits.Media, r.QuotaUsed.Media))
+	if exceeds(r.Cost.API, r.QuotaLimits.API, r.QuotaUsed.API) || exceeds(r.Cost.Media, r.QuotaLimits.Media, r.QuotaUsed.Media) {
+		fmt.Fprintln(w, "The backup would stop early and finish after the quota resets")
+	}
+}
+
+func quotaLeft(limit, used int64) string {
+	if limit == 0 {
+		return "unlimited"
+	}
+	return fmt.Sprint(max(limit-used, 0))
+}
+
+func exceeds(cost, limit, used int64) bool {
+	return limit > 0 && cost > limit-used
+}
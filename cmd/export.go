@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"io"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/gphotosuploader/googlemirror/api/photoslibrary/v1"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/ttomsu/gphotobackup/internal"
+	"github.com/ttomsu/gphotobackup/internal/backup"
+	"github.com/ttomsu/gphotobackup/internal/storage"
+	"github.com/ttomsu/gphotobackup/internal/utils"
+	"go.uber.org/zap"
+)
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.PersistentFlags().Int("year", 0, "Export items created in this year")
+	exportCmd.PersistentFlags().String("albumID", "", "Export this album, from the API")
+	exportCmd.PersistentFlags().String("album", "", "Export this album by title, from --from")
+	exportCmd.PersistentFlags().String("from", "", "Read from this existing backup instead of the API")
+	exportCmd.PersistentFlags().String("format", "tar", "Archive format, tar or zip")
+	exportCmd.PersistentFlags().String("volume-size", "", "Split the archive into volumes of this size, e.g. 4GiB")
+	exportCmd.PersistentFlags().Int("workers", 3, "Concurrent download workers")
+	exportCmd.PersistentFlags().Bool("sidecars", true, "Include a .json file of each item's metadata")
+}
+
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Write a year or album to a single tar or zip archive, with a manifest of checksums",
+	// Flags are bound here rather than in init, as some share names with
+	// backup's flags.
+	PreRunE: func(cmd *cobra.Command, _ []string) error {
+		return viper.BindPFlags(cmd.PersistentFlags())
+	},
+	RunE: func(_ *cobra.Command, args []string) error {
+		logger := NewLogger()
+		archivePath := viper.GetString("out")
+		if archivePath == "" {
+			return errors.New("--out is required")
+		}
+		if viper.GetInt("year") == 0 && viper.GetString("albumID") == "" && viper.GetString("album") == "" {
+			return errors.New("Must specify either --year, --albumID or --album")
+		}
+		var volumeSize int64
+		if vs := viper.GetString("volume-size"); vs != "" {
+			var err error
+			if volumeSize, err = utils.ParseBytes(vs); err != nil {
+				return errors.Wrap(err, "invalid --volume-size")
+			}
+		}
+
+		out, err := storage.NewVolumeWriter(archivePath, volumeSize)
+		if err != nil {
+			return errors.Wrap(err, "creating archive")
+		}
+		if err := writeArchive(out, logger); err != nil {
+			_ = out.Close()
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return errors.Wrap(err, "closing archive")
+		}
+		logger.Infof("Exported to %v", archivePath)
+		return nil
+	},
+}
+
+// writeArchive exports the year or album to out, from --from or the API.
+func writeArchive(out io.Writer, logger *zap.SugaredLogger) error {
+	archive, err := storage.NewArchive(out, viper.GetString("format"))
+	if err != nil {
+		return err
+	}
+	if from := viper.GetString("from"); from != "" {
+		err = exportFromBackup(archive, from)
+	} else {
+		err = exportFromAPI(archive, logger)
+	}
+	if err != nil {
+		return err
+	}
+	return errors.Wrap(archive.Close(), "finishing archive")
+}
+
+// exportFromBackup copies the year or album's directory from an existing
+// backup into the archive.
+func exportFromBackup(archive *storage.Archive, from string) error {
+	src, err := storage.Open(from)
+	if err != nil {
+		return errors.Wrap(err, "opening --from")
+	}
+	dir := path.Join("albums", utils.Sanitize(viper.GetString("album")))
+	if year := viper.GetInt("year"); year != 0 {
+		dir = strconv.Itoa(year)
+	}
+	return storage.Walk(src, dir, func(name string, fi *storage.FileInfo) error {
+		return copyFile(src, archive, name, fi)
+	})
+}
+
+// exportFromAPI downloads the year or album straight into the archive.
+func exportFromAPI(archive *storage.Archive, logger *zap.SugaredLogger) error {
+	client, err := internal.NewClient()
+	if err != nil {
+		return errors.Wrapf(err, "new client")
+	}
+	bs, err := backup.NewSession(client, archive, viper.GetInt("workers"), logger)
+	if err != nil {
+		return errors.Wrapf(err, "new session")
+	}
+
+	searchReq := &photoslibrary.SearchMediaItemsRequest{PageSize: 100}
+	if albumID := viper.GetString("albumID"); albumID != "" {
+		searchReq.AlbumId = albumID
+	} else if year := viper.GetInt("year"); year != 0 {
+		searchReq.Filters = dateRangeFilters(
+			time.Date(year, 1, 1, 0, 0, 0, 0, time.Local),
+			time.Date(year, 12, 31, 0, 0, 0, 0, time.Local))
+	} else {
+		return errors.New("--album requires --from, use --albumID to export from the API")
+	}
+	logger.Infof("Exporting from the API...")
+	if err := bs.Start(searchReq); err != nil {
+		return err
+	}
+	if failed := bs.Stats().Failed.Load(); failed > 0 {
+		return errors.Errorf("%v items could not be downloaded, the archive is incomplete", failed)
+	}
+	return nil
+}
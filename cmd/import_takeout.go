@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gphotosuploader/googlemirror/api/photoslibrary/v1"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/ttomsu/gphotobackup/internal"
+	"github.com/ttomsu/gphotobackup/internal/lock"
+	"github.com/ttomsu/gphotobackup/internal/takeout"
+)
+
+func init() {
+	rootCmd.AddCommand(importTakeoutCmd)
+
+	importTakeoutCmd.Flags().StringSlice("encrypt-recipient", nil, "Encrypt with age for this recipient (age1...) or recipients file, as backup does")
+	importTakeoutCmd.Flags().String("encrypt-passphrase-file", "", "Encrypt with age using the passphrase in this file, as backup does")
+	importTakeoutCmd.Flags().Bool("obfuscate-names", false, "Name files by media item ID, as backup does")
+	importTakeoutCmd.Flags().Duration("wait", 0, "If a backup is writing to --out, wait this long for it to finish")
+}
+
+var importTakeoutCmd = &cobra.Command{
+	Use:   "import-takeout takeout-001.zip [takeout-002.tgz ...]",
+	Short: "Place files from Google Takeout archives into --out, so backup skips them",
+	Args:  cobra.MinimumNArgs(1),
+	// Flags are bound here rather than in init, as they share names with
+	// backup's flags.
+	PreRunE: func(cmd *cobra.Command, _ []string) error {
+		return viper.BindPFlags(cmd.Flags())
+	},
+	RunE: func(_ *cobra.Command, args []string) error {
+		logger := NewLogger()
+		client, err := internal.NewClient()
+		if err != nil {
+			return errors.Wrapf(err, "new client")
+		}
+		svc, err := internal.NewPhotosService(client)
+		if err != nil {
+			return err
+		}
+		out := profileOut(viper.GetString("out"), viper.GetString("profile"))
+		store, err := openOut(out)
+		if err != nil {
+			return err
+		}
+		runLock, err := lock.Acquire(lockPath(out), viper.GetDuration("wait"), 10*time.Second)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := runLock.Release(); err != nil {
+				logger.Warnf("Releasing lock: %v", err)
+			}
+		}()
+
+		im := &takeout.Importer{
+			Store: store,
+			// Search a day either side, as Takeout times are UTC but the API
+			// filters on the item's local date.
+			Search: func(day time.Time) ([]*photoslibrary.MediaItem, error) {
+				var items []*photoslibrary.MediaItem
+				searchReq := &photoslibrary.SearchMediaItemsRequest{
+					PageSize: 100,
+					Filters:  dateRangeFilters(day.AddDate(0, 0, -1), day.AddDate(0, 0, 1)),
+				}
+				err := svc.MediaItems.Search(searchReq).Pages(context.Background(), func(resp *photoslibrary.SearchMediaItemsResponse) error {
+					items = append(items, resp.MediaItems...)
+					return nil
+				})
+				return items, err
+			},
+			Logger:    logger,
+			Obfuscate: viper.GetBool("obfuscate-names"),
+			Sidecars:  viper.GetBool("sidecars"),
+		}
+
+		result := &takeout.Result{}
+		logger.Infof("Importing %v...", strings.Join(args, ", "))
+		if err := im.Import(args, result); err != nil {
+			return err
+		}
+
+		for _, name := range result.Unmatched {
+			logger.Warnf("Unmatched: %v", name)
+		}
+		logger.Infof("Imported %v items, %v already backed up, %v unmatched",
+			result.Imported, result.Existing, len(result.Unmatched))
+		return nil
+	},
+}
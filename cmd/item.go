@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"encoding/json"
-	"github.com/gphotosuploader/googlemirror/api/photoslibrary/v1"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -25,7 +24,7 @@ var itemCmd = &cobra.Command{
 			return errors.Wrapf(err, "new client")
 		}
 
-		cl, err := photoslibrary.New(client)
+		cl, err := internal.NewPhotosService(client)
 		if err != nil {
 			return err
 		}
@@ -1,15 +1,111 @@
 package cmd
 
 import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/spf13/viper"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
-	"log"
+	"golang.org/x/term"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+func init() {
+	rootCmd.PersistentFlags().String("log-format", "console", "Log format: console or json")
+	rootCmd.PersistentFlags().String("log-level", "info", "Log level: debug, info, warn or error")
+	rootCmd.PersistentFlags().String("log-file", "", "Write logs to this file instead of stderr, rotating it as it grows")
+	rootCmd.PersistentFlags().Int("log-max-size", 100, "Rotate the log file when it reaches this many megabytes")
+	rootCmd.PersistentFlags().Int("log-max-age", 30, "Delete rotated log files after this many days")
+	rootCmd.PersistentFlags().Int("log-max-backups", 10, "Keep at most this many rotated log files")
+
+	checkError(viper.BindPFlags(rootCmd.PersistentFlags()))
+}
+
+var (
+	loggerOnce sync.Once
+	logger     *zap.SugaredLogger
+
+	// logOutput is stderr unless the progress view is drawing there.
+	logOutput = &switchWriter{w: os.Stderr}
 )
 
+// switchWriter forwards writes to a writer that can be swapped.
+type switchWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *switchWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// Set swaps in w and returns the previous writer.
+func (s *switchWriter) Set(w io.Writer) io.Writer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	prev := s.w
+	s.w = w
+	return prev
+}
+
+// NewLogger returns the logger configured by the --log-* flags. It is built
+// once, so every command shares the same log file.
 func NewLogger() *zap.SugaredLogger {
-	logger, err := zap.NewDevelopment(zap.AddStacktrace(zapcore.ErrorLevel))
+	loggerOnce.Do(func() {
+		l, err := buildLogger()
+		if err != nil {
+			log.Fatalf("Error establishing logger: %v", err)
+		}
+		logger = l.Sugar()
+	})
+	return logger
+}
+
+func buildLogger() (*zap.Logger, error) {
+	level, err := zapcore.ParseLevel(viper.GetString("log-level"))
 	if err != nil {
-		log.Fatalf("Error establishing logger: %v", err)
+		return nil, err
+	}
+	// --verbose predates --log-level and now just turns on debug logs.
+	if viper.GetBool("verbose") && level > zapcore.DebugLevel {
+		level = zapcore.DebugLevel
 	}
-	return logger.Sugar()
+
+	var out zapcore.WriteSyncer = zapcore.AddSync(logOutput)
+	toTerminal := term.IsTerminal(int(os.Stderr.Fd()))
+	if file := viper.GetString("log-file"); file != "" {
+		out = zapcore.AddSync(&lumberjack.Logger{
+			Filename:   file,
+			MaxSize:    viper.GetInt("log-max-size"),
+			MaxAge:     viper.GetInt("log-max-age"),
+			MaxBackups: viper.GetInt("log-max-backups"),
+		})
+		toTerminal = false
+	}
+
+	var enc zapcore.Encoder
+	switch format := viper.GetString("log-format"); format {
+	case "json":
+		cfg := zap.NewProductionEncoderConfig()
+		cfg.EncodeTime = zapcore.ISO8601TimeEncoder
+		cfg.EncodeDuration = zapcore.SecondsDurationEncoder
+		enc = zapcore.NewJSONEncoder(cfg)
+	case "console", "":
+		cfg := zap.NewDevelopmentEncoderConfig()
+		cfg.EncodeDuration = zapcore.StringDurationEncoder
+		if toTerminal {
+			cfg.EncodeLevel = zapcore.CapitalColorLevelEncoder
+		}
+		enc = zapcore.NewConsoleEncoder(cfg)
+	default:
+		return nil, fmt.Errorf("unknown --log-format %q, use console or json", format)
+	}
+
+	return zap.New(zapcore.NewCore(enc, out, level), zap.AddCaller()), nil
 }
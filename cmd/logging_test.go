@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestBuildLoggerJSONFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "gphotobackup.log")
+	viper.Set("log-format", "json")
+	viper.Set("log-level", "warn")
+	viper.Set("log-file", file)
+	defer func() {
+		for _, k := range []string{"log-format", "log-level", "log-file"} {
+			viper.Set(k, nil)
+		}
+	}()
+
+	l, err := buildLogger()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Sugar().Infow("hidden")
+	l.Sugar().Warnw("Downloaded", "item", "abc", "bytes", 42)
+	_ = l.Sync()
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := map[string]any{}
+	if err := json.Unmarshal(data, &line); err != nil {
+		t.Fatalf("expected a single JSON line, got %q: %v", data, err)
+	}
+	if line["msg"] != "Downloaded" || line["item"] != "abc" || line["bytes"] != float64(42) {
+		t.Errorf("unexpected log line: %v", line)
+	}
+
+	viper.Set("log-format", "xml")
+	if _, err := buildLogger(); err == nil {
+		t.Error("expected unknown format to fail")
+	}
+}
@@ -1,16 +1,23 @@
 package cmd
 
 import (
+	"bufio"
 	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"fmt"
 	"github.com/gphotosuploader/googlemirror/api/photoslibrary/v1"
 	"github.com/pkg/errors"
 	"github.com/spf13/viper"
 	"github.com/ttomsu/gphotobackup/internal"
+	"go.uber.org/zap"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"runtime"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
@@ -25,6 +32,7 @@ func init() {
 	loginCmd.MarkFlagRequired("creds")
 
 	loginCmd.PersistentFlags().Bool("browser", true, "Use a local browser to obtain user consent.")
+	loginCmd.PersistentFlags().Bool("device", false, "Show a code to enter on another device to obtain user consent.")
 
 	checkError(viper.BindPFlags(loginCmd.PersistentFlags()))
 }
@@ -43,57 +51,26 @@ var loginCmd = &cobra.Command{
 		if err != nil {
 			return errors.Wrapf(err, "cfg from creds data")
 		}
-		cfg.RedirectURL = "http://localhost:9898/login"
+		cfg.Endpoint.DeviceAuthURL = google.Endpoint.DeviceAuthURL
 
-		url := cfg.AuthCodeURL("state", oauth2.AccessTypeOffline)
-		codeChan := make(chan string, 1)
-		var server *http.Server
-
-		if viper.GetBool("browser") {
-			err = openInBrowser(url)
+		var token *oauth2.Token
+		if viper.GetBool("device") {
+			da, err := cfg.DeviceAuth(context.Background())
 			if err != nil {
-				return errors.Wrapf(err, "auth code url")
+				logger.Warnf("Device login is not available for these credentials, falling back to pasting the code: %v", err)
+				viper.Set("browser", false)
+			} else if token, err = deviceToken(context.Background(), cfg, da, logger); err != nil {
+				return err
 			}
-
-			server := &http.Server{Addr: ":9898"}
-			http.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
-				codeChan <- r.URL.Query().Get("code")
-				w.Write([]byte("You can close this window now!"))
-			})
-			go server.ListenAndServe()
-
-			logger.Info("Waiting for approval...")
-		} else {
-			logger.Infof("In a browser, navigate to:\n%v\n\n", url)
-			logger.Info("Enter query parameter 'code' here:")
-			var c string
-			if _, err := fmt.Scan(&c); err != nil {
-				return errors.Wrap(err, "getting code from command line")
-			} else if c == "" {
-				return errors.New("code cannot be blank")
-			}
-			codeChan <- c
 		}
-
-		timeout := time.NewTimer(2 * time.Minute)
-		var code string
-		select {
-		case code = <-codeChan:
-		case <-timeout.C:
-		}
-		if server != nil {
-			_ = server.Shutdown(context.Background())
-		}
-		if code == "" {
-			return errors.New("Did not get code in time.")
-		}
-		token, err := cfg.Exchange(context.Background(), code)
-		if err != nil {
-			return errors.Wrapf(err, "exchange")
+		if token == nil {
+			if token, err = authCodeToken(cfg, logger); err != nil {
+				return err
+			}
 		}
 
-		if err := internal.WriteJSONToConfigDir(internal.TokenFilename, token); err != nil {
-			return errors.Wrapf(err, "writing token.json")
+		if err := internal.SaveToken(token); err != nil {
+			return errors.Wrapf(err, "saving token")
 		}
 		if err := internal.WriteToConfigDir(internal.OAuthClientFilename, oauthCreds); err != nil {
 			return errors.Wrapf(err, "writing oauth_client.json")
@@ -103,6 +80,143 @@ var loginCmd = &cobra.Command{
 	},
 }
 
+// deviceToken shows the user code from da and polls until the user has
+// approved it on another device.
+func deviceToken(ctx context.Context, cfg *oauth2.Config, da *oauth2.DeviceAuthResponse, logger *zap.SugaredLogger) (*oauth2.Token, error) {
+	if da.VerificationURIComplete != "" {
+		logger.Infof("On any device, navigate to:\n%v\n\n", da.VerificationURIComplete)
+	} else {
+		logger.Infof("On any device, navigate to:\n%v\n\nand enter the code: %v\n\n", da.VerificationURI, da.UserCode)
+	}
+	logger.Info("Waiting for approval...")
+	token, err := cfg.DeviceAccessToken(ctx, da)
+	return token, errors.Wrap(err, "device login")
+}
+
+// authCodeToken obtains consent in a browser, redirecting back to a local
+// listener or asking the user to paste the code, and exchanges it for a token.
+func authCodeToken(cfg *oauth2.Config, logger *zap.SugaredLogger) (*oauth2.Token, error) {
+	// Only listen on loopback, on whichever port is free.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, errors.Wrap(err, "listening for redirect")
+	}
+	defer listener.Close()
+	cfg.RedirectURL = fmt.Sprintf("http://%v/login", listener.Addr())
+
+	state, err := randomState()
+	if err != nil {
+		return nil, err
+	}
+	verifier := oauth2.GenerateVerifier()
+	authURL := cfg.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.S256ChallengeOption(verifier))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	var code string
+	if viper.GetBool("browser") {
+		code, err = loopbackCode(ctx, listener, authURL, state, logger)
+	} else {
+		// Nothing will be listening on the remote browser's machine anyway.
+		_ = listener.Close()
+		code, err = manualCode(authURL, state, logger)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := cfg.Exchange(context.Background(), code, oauth2.VerifierOption(verifier))
+	return token, errors.Wrapf(err, "exchange")
+}
+
+type authResult struct {
+	code string
+	err  error
+}
+
+// loopbackCode opens authURL in a browser and waits for it to redirect back to
+// listener with the code.
+func loopbackCode(ctx context.Context, listener net.Listener, authURL, state string, logger *zap.SugaredLogger) (string, error) {
+	results := make(chan authResult, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		code, err := codeFromRedirect(r.URL.Query(), state)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		} else {
+			_, _ = w.Write([]byte("You can close this window now!"))
+		}
+		select {
+		case results <- authResult{code: code, err: err}:
+		default:
+		}
+	})
+	server := &http.Server{Handler: mux}
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	if err := openInBrowser(authURL); err != nil {
+		return "", errors.Wrapf(err, "auth code url")
+	}
+	logger.Info("Waiting for approval...")
+
+	select {
+	case result := <-results:
+		return result.code, result.err
+	case <-ctx.Done():
+		return "", errors.New("Did not get code in time.")
+	}
+}
+
+// manualCode asks the user to visit authURL and paste the URL they are
+// redirected to, or just its code parameter.
+func manualCode(authURL, state string, logger *zap.SugaredLogger) (string, error) {
+	logger.Infof("In a browser, navigate to:\n%v\n\n", authURL)
+	logger.Info("The browser will fail to load the page it's sent to afterwards. Paste that page's URL, or its 'code' query parameter, here:")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.Wrap(err, "getting code from command line")
+	}
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return "", errors.New("code cannot be blank")
+	}
+	if u, err := url.Parse(line); err == nil && u.Query().Has("code") {
+		return codeFromRedirect(u.Query(), state)
+	}
+	return line, nil
+}
+
+// codeFromRedirect returns the code from the redirect's query, after checking
+// its state matches the one sent.
+func codeFromRedirect(q url.Values, state string) (string, error) {
+	if q.Get("state") != state {
+		return "", errors.New("state mismatch, please try logging in again")
+	}
+	if e := q.Get("error"); e != "" {
+		return "", errors.Errorf("authorization failed: %v", e)
+	}
+	code := q.Get("code")
+	if code == "" {
+		return "", errors.New("no code in redirect")
+	}
+	return code, nil
+}
+
+func randomState() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "generating state")
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
 func openInBrowser(url string) (err error) {
 	switch runtime.GOOS {
 	case "linux":
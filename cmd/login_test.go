@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+func TestDeviceToken(t *testing.T) {
+	polls := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device/code", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"device_code": "dev-code", "user_code": "ABCD-EFGH", "verification_url": "https://example.com/device", "expires_in": 60, "interval": 1}`))
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		if r.Form.Get("device_code") != "dev-code" {
+			t.Errorf("unexpected device code: %v", r.Form.Get("device_code"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		polls++
+		if polls < 2 {
+			w.WriteHeader(http.StatusPreconditionRequired)
+			_, _ = w.Write([]byte(`{"error": "authorization_pending"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token": "access", "refresh_token": "refresh", "expires_in": 3600}`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	cfg := &oauth2.Config{
+		ClientID: "client",
+		Endpoint: oauth2.Endpoint{
+			DeviceAuthURL: ts.URL + "/device/code",
+			TokenURL:      ts.URL + "/token",
+		},
+	}
+	da, err := cfg.DeviceAuth(context.Background())
+	if err != nil {
+		t.Fatalf("device auth: %v", err)
+	}
+	if da.UserCode != "ABCD-EFGH" || da.VerificationURI != "https://example.com/device" {
+		t.Fatalf("unexpected device auth response: %+v", da)
+	}
+	token, err := deviceToken(context.Background(), cfg, da, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatalf("device token: %v", err)
+	}
+	if token.RefreshToken != "refresh" || polls != 2 {
+		t.Fatalf("unexpected token %+v after %v polls", token, polls)
+	}
+}
+
+func TestCodeFromRedirect(t *testing.T) {
+	if code, err := codeFromRedirect(url.Values{"state": {"s"}, "code": {"c"}}, "s"); err != nil || code != "c" {
+		t.Fatalf("expected code, got: %v, %v", code, err)
+	}
+	if _, err := codeFromRedirect(url.Values{"state": {"other"}, "code": {"c"}}, "s"); err == nil {
+		t.Fatalf("expected state mismatch error")
+	}
+	if _, err := codeFromRedirect(url.Values{"state": {"s"}, "error": {"access_denied"}}, "s"); err == nil {
+		t.Fatalf("expected access_denied error")
+	}
+}
@@ -31,9 +31,9 @@ var printCmd = &cobra.Command{
 			return errors.Wrapf(err, "new client")
 		}
 
-		svc, err := photoslibrary.New(client)
+		svc, err := internal.NewPhotosService(client)
 		if err != nil {
-			return errors.Wrap(err, "service client")
+			return err
 		}
 
 		details := make([]*albumDetail, 0, 256)
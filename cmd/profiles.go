@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/ttomsu/gphotobackup/internal"
+)
+
+func init() {
+	rootCmd.AddCommand(profilesCmd)
+	profilesCmd.AddCommand(profilesListCmd)
+	profilesCmd.AddCommand(profilesRemoveCmd)
+}
+
+var profilesCmd = &cobra.Command{
+	Use:   "profiles",
+	Short: "Manage the Google account profiles created with 'login --profile'",
+}
+
+var profilesListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List profiles that have logged in",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		profiles, err := internal.Profiles()
+		if err != nil {
+			return err
+		}
+		for _, p := range profiles {
+			fmt.Println(p)
+		}
+		return nil
+	},
+}
+
+var profilesRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Delete a profile's credentials and state. Backed up files are kept.",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := internal.RemoveProfile(args[0]); err != nil {
+			return errors.Wrap(err, "removing profile")
+		}
+		fmt.Printf("Removed profile %v\n", args[0])
+		return nil
+	},
+}
@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/ttomsu/gphotobackup/internal/backup"
+	"github.com/ttomsu/gphotobackup/internal/progress"
+	"go.uber.org/zap"
+	"golang.org/x/term"
+)
+
+// startProgress reports bs's progress as --progress asks, until the
+// returned func is called.
+func startProgress(bs *backup.Session, logger *zap.SugaredLogger) (func(), error) {
+	mode := viper.GetString("progress")
+	interval := viper.GetDuration("progress-interval")
+	switch mode {
+	case "off":
+		return func() {}, nil
+	case "auto", "tty", "log":
+	default:
+		return nil, errors.Errorf("--progress must be auto, tty, log or off, not %q", mode)
+	}
+	if interval <= 0 {
+		return nil, errors.Errorf("--progress-interval must be positive, not %v", interval)
+	}
+	// The view is drawn on stderr, where the log lines it takes over go.
+	stderrTTY := term.IsTerminal(int(os.Stderr.Fd()))
+	if mode == "auto" {
+		mode = "log"
+		if stderrTTY {
+			mode = "tty"
+		}
+	}
+	tracker := progress.NewTracker()
+	bs.SetProgress(tracker)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	if mode == "log" {
+		go func() {
+			defer close(done)
+			progress.Log(ctx, logger, tracker, interval)
+		}()
+		return func() { cancel(); <-done }, nil
+	}
+
+	width := func() int {
+		w, _, err := term.GetSize(int(os.Stderr.Fd()))
+		if err != nil {
+			return 0
+		}
+		return w
+	}
+	view := progress.NewTerminal(os.Stderr, tracker, width)
+	// Log lines go through the view so they print above it, not over it.
+	prevOutput := logOutput.Set(view)
+	go func() {
+		defer close(done)
+		view.Run(ctx, 500*time.Millisecond)
+	}()
+	return func() {
+		cancel()
+		<-done
+		logOutput.Set(prevOutput)
+	}, nil
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+)
+
+func TestProgressFlags(t *testing.T) {
+	flags := backupCmd.PersistentFlags()
+	t.Cleanup(func() {
+		_ = flags.Set("progress", "auto")
+		_ = flags.Set("progress-interval", "30s")
+	})
+
+	if err := flags.Set("progress", "log"); err != nil {
+		t.Fatal(err)
+	}
+	if err := flags.Set("progress-interval", "5s"); err != nil {
+		t.Fatal(err)
+	}
+	if got := viper.GetString("progress"); got != "log" {
+		t.Errorf("progress = %q, want log", got)
+	}
+	if got := viper.GetDuration("progress-interval"); got != 5*time.Second {
+		t.Errorf("progress-interval = %v, want 5s", got)
+	}
+
+	viper.Set("progress-interval", 0)
+	defer viper.Set("progress-interval", nil)
+	if _, err := startProgress(nil, zap.NewNop().Sugar()); err == nil {
+		t.Error("expected a zero --progress-interval to be rejected")
+	}
+}
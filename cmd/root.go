@@ -1,15 +1,25 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
 
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"github.com/ttomsu/gphotobackup/internal"
+	"github.com/ttomsu/gphotobackup/internal/quota"
 )
 
+// ExitLoginRequired is the exit code when the saved credentials need to be
+// renewed with 'gphotobackup login'.
+const ExitLoginRequired = 3
+
 var cfgFile string
 
 // rootCmd represents the base command when called without any subcommands
@@ -23,6 +33,9 @@ var rootCmd = &cobra.Command{
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
+		if errors.Is(err, internal.ErrLoginRequired) {
+			os.Exit(ExitLoginRequired)
+		}
 		os.Exit(1)
 	}
 }
@@ -32,8 +45,13 @@ func init() {
 
 	rootCmd.PersistentFlags().String("out", "", "")
 	rootCmd.PersistentFlags().String("id", "", "Item ID")
+	rootCmd.PersistentFlags().String("profile", "", "Google account profile; keeps its own credentials and output subtree")
 
 	checkError(viper.BindPFlags(rootCmd.PersistentFlags()))
+
+	viper.SetDefault("quota.apiPerDay", quota.DefaultAPIPerDay)
+	viper.SetDefault("quota.mediaPerDay", quota.DefaultMediaPerDay)
+	viper.SetDefault("quota.requestsPerSecond", 10)
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -62,6 +80,24 @@ func initConfig() {
 	if err := viper.ReadInConfig(); err == nil {
 		fmt.Println("Using config file:", viper.ConfigFileUsed())
 	}
+
+	if err := internal.SetProfile(viper.GetString("profile")); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// profileOut returns the output location for a profile, which is a subtree of
+// out named after the profile. The default profile writes to out itself.
+func profileOut(out, profile string) string {
+	if out == "" || profile == "" || profile == internal.DefaultProfile {
+		return out
+	}
+	if u, err := url.Parse(out); err == nil && u.Scheme != "" && u.Host != "" {
+		u.Path = path.Join(u.Path, profile)
+		return u.String()
+	}
+	return filepath.Join(out, profile)
 }
 
 func checkError(err error) {
@@ -0,0 +1,193 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gphotosuploader/googlemirror/api/photoslibrary/v1"
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/googleapi"
+)
+
+var tokenInfoURL = "https://oauth2.googleapis.com/tokeninfo"
+
+// Check is the outcome of one step of CheckAuth, with a hint on how to fix
+// it when it failed.
+type Check struct {
+	OK      bool   `json:"ok"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Hint    string `json:"hint,omitempty"`
+}
+
+// AuthStatus describes the saved credentials and whether they work.
+type AuthStatus struct {
+	Profile     string    `json:"profile"`
+	SecretStore string    `json:"secretStore,omitempty"`
+	TokenExpiry time.Time `json:"tokenExpiry,omitempty"`
+	Scopes      []string  `json:"scopes,omitempty"`
+
+	OAuthClient Check `json:"oauthClient"`
+	Token       Check `json:"token"`
+	Refresh     Check `json:"refresh"`
+	Scope       Check `json:"scope"`
+	API         Check `json:"api"`
+}
+
+// OK is true when every check passed.
+func (s *AuthStatus) OK() bool {
+	for _, c := range []Check{s.OAuthClient, s.Token, s.Refresh, s.Scope, s.API} {
+		if !c.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// LoginRequired is true when the only fix is to run login again.
+func (s *AuthStatus) LoginRequired() bool {
+	return !s.Token.OK || strings.Contains(s.Refresh.Error, "invalid_grant")
+}
+
+// CheckAuth loads the OAuth client and token, refreshes the token and makes
+// a minimal API call, stopping at the first step that fails.
+func CheckAuth(ctx context.Context) *AuthStatus {
+	s := &AuthStatus{Profile: currentProfile}
+	if s.Profile == "" {
+		s.Profile = DefaultProfile
+	}
+	skip := func(checks ...*Check) *AuthStatus {
+		for _, c := range checks {
+			c.Skipped = true
+		}
+		return s
+	}
+
+	var cfg *oauth2.Config
+	clientData, err := ReadFromConfigDir(OAuthClientFilename)
+	if err == nil {
+		cfg, err = google.ConfigFromJSON(clientData, photoslibrary.PhotoslibraryReadonlyScope)
+	}
+	if err != nil {
+		s.OAuthClient = failed(err, "Download the OAuth client JSON again and run 'gphotobackup login --creds <file>'")
+		return skip(&s.Token, &s.Refresh, &s.Scope, &s.API)
+	}
+	s.OAuthClient.OK = true
+
+	data, store, err := LoadToken()
+	token := &oauth2.Token{}
+	if err == nil {
+		s.SecretStore = store.Name()
+		err = json.Unmarshal(data, token)
+	}
+	if err == nil && token.RefreshToken == "" {
+		err = errors.New("token has no refresh token")
+	}
+	if err != nil {
+		s.Token = failed(err, "Run 'gphotobackup login'")
+		return skip(&s.Refresh, &s.Scope, &s.API)
+	}
+	s.Token.OK = true
+	s.TokenExpiry = token.Expiry
+
+	// Force a refresh by dropping the access token.
+	src := &persistingTokenSource{
+		src:   cfg.TokenSource(ctx, &oauth2.Token{RefreshToken: token.RefreshToken}),
+		store: store,
+		last:  token,
+	}
+	fresh, err := src.Token()
+	if err != nil {
+		s.Refresh = failed(err, refreshHint(err))
+		return skip(&s.Scope, &s.API)
+	}
+	s.Refresh.OK = true
+
+	client := oauth2.NewClient(ctx, oauth2.StaticTokenSource(fresh))
+	if s.Scopes, err = tokenScopes(client, fresh.AccessToken); err != nil {
+		s.Scope = failed(err, "Check network access to oauth2.googleapis.com")
+	} else if !containsScope(s.Scopes, photoslibrary.PhotoslibraryReadonlyScope) {
+		s.Scope = failed(errors.Errorf("token lacks %v", photoslibrary.PhotoslibraryReadonlyScope),
+			"Run 'gphotobackup login' again and grant access to Google Photos")
+	} else {
+		s.Scope.OK = true
+	}
+
+	svc, err := NewPhotosService(client)
+	if err == nil {
+		_, err = svc.Albums.List().PageSize(1).Context(ctx).Do()
+	}
+	if err != nil {
+		s.API = failed(err, apiHint(err))
+		return s
+	}
+	s.API.OK = true
+	return s
+}
+
+func failed(err error, hint string) Check {
+	return Check{Error: err.Error(), Hint: hint}
+}
+
+func refreshHint(err error) string {
+	if errors.Is(err, ErrLoginRequired) {
+		return "The refresh token expired or was revoked. Run 'gphotobackup login'. Apps in \"Testing\" publishing status get tokens that expire after 7 days."
+	}
+	var re *oauth2.RetrieveError
+	if errors.As(err, &re) && (re.ErrorCode == "invalid_client" || re.ErrorCode == "unauthorized_client") {
+		return "The OAuth client was deleted or its secret changed. Download it again and run 'gphotobackup login --creds <file>'"
+	}
+	return "Check network access to oauth2.googleapis.com"
+}
+
+func apiHint(err error) string {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		return "Check network access to photoslibrary.googleapis.com"
+	}
+	switch {
+	case gerr.Code == http.StatusForbidden && (strings.Contains(gerr.Body, "SERVICE_DISABLED") || strings.Contains(gerr.Message, "has not been used")):
+		return "Enable the Photos Library API for the OAuth client's project: https://console.cloud.google.com/apis/library/photoslibrary.googleapis.com"
+	case gerr.Code == http.StatusForbidden:
+		return "The token isn't allowed to read Google Photos. Run 'gphotobackup login' again and grant access"
+	case gerr.Code == http.StatusUnauthorized:
+		return "The API rejected the token. Run 'gphotobackup login'"
+	case gerr.Code == http.StatusTooManyRequests:
+		return "The API quota is exhausted. Wait for it to reset, usually at midnight Pacific time"
+	}
+	return "The Photos Library API returned an unexpected error"
+}
+
+// tokenScopes asks Google which scopes the access token was granted.
+func tokenScopes(client *http.Client, accessToken string) ([]string, error) {
+	resp, err := client.Get(tokenInfoURL + "?access_token=" + url.QueryEscape(accessToken))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("tokeninfo: %v", resp.Status)
+	}
+	info := struct {
+		Scope string `json:"scope"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, errors.Wrap(err, "tokeninfo")
+	}
+	return strings.Fields(info.Scope), nil
+}
+
+func containsScope(scopes []string, want string) bool {
+	for _, s := range scopes {
+		if s == want || s == "https://www.googleapis.com/auth/photoslibrary" {
+			return true
+		}
+	}
+	return false
+}
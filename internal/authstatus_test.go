@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestCheckAuth(t *testing.T) {
+	tempConfigDir(t)
+	viper.Set("secrets.backend", "plain")
+	defer viper.Set("secrets.backend", nil)
+
+	apiStatus := http.StatusOK
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token": "fresh", "expires_in": 3600}`))
+	})
+	mux.HandleFunc("/tokeninfo", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"scope": "https://www.googleapis.com/auth/photoslibrary.readonly"}`))
+	})
+	mux.HandleFunc("/v1/albums", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("pageSize") != "1" {
+			t.Errorf("expected pageSize=1, got %v", r.URL.RawQuery)
+		}
+		w.WriteHeader(apiStatus)
+		if apiStatus != http.StatusOK {
+			_, _ = w.Write([]byte(`{"error": {"code": 403, "message": "Photos Library API has not been used in project 1 before or it is disabled.", "status": "PERMISSION_DENIED"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"albums": []}`))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	oldTokenInfoURL := tokenInfoURL
+	defer func() { tokenInfoURL = oldTokenInfoURL }()
+	tokenInfoURL = ts.URL + "/tokeninfo"
+	viper.Set("api.endpoint", ts.URL)
+	defer viper.Set("api.endpoint", nil)
+
+	status := CheckAuth(context.Background())
+	if status.OAuthClient.OK || !status.Token.Skipped {
+		t.Fatalf("expected missing oauth client to fail, got %+v", status)
+	}
+
+	client := fmt.Sprintf(`{"installed": {"client_id": "id", "client_secret": "secret", "token_uri": "%v/token", "redirect_uris": ["http://127.0.0.1"]}}`, ts.URL)
+	if err := WriteToConfigDir(OAuthClientFilename, []byte(client)); err != nil {
+		t.Fatal(err)
+	}
+	status = CheckAuth(context.Background())
+	if status.Token.OK || !status.LoginRequired() {
+		t.Fatalf("expected missing token to require login, got %+v", status)
+	}
+
+	if err := WriteToConfigDir(TokenFilename, []byte(`{"access_token": "old", "refresh_token": "refresh"}`)); err != nil {
+		t.Fatal(err)
+	}
+	status = CheckAuth(context.Background())
+	if !status.OK() {
+		t.Fatalf("expected all checks to pass, got %+v", status)
+	}
+
+	apiStatus = http.StatusForbidden
+	status = CheckAuth(context.Background())
+	if status.API.OK || status.LoginRequired() || status.API.Hint == "" {
+		t.Fatalf("expected API check to fail with a hint, got %+v", status.API)
+	}
+}
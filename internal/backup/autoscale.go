@@ -0,0 +1,88 @@
+package backup
+
+import (
+	"sync"
+	"time"
+)
+
+// scaleInterval is how often the autoscaler resizes the pool.
+var scaleInterval = 10 * time.Second
+
+// autoscaler sizes the worker pool AIMD-style: one more worker after each
+// interval where throughput held up, and half as many after 429s, server
+// errors or a latency spike.
+type autoscaler struct {
+	min, max int
+
+	mu          sync.Mutex
+	responses   int
+	congested   int
+	latency     time.Duration
+	bytes       int64
+	lastRate    float64
+	baseLatency time.Duration
+}
+
+func newAutoscaler(lo, hi int) *autoscaler {
+	lo = max(lo, 1)
+	return &autoscaler{min: lo, max: max(hi, lo)}
+}
+
+// observe records one download response. A status of 0 is a failed request.
+// It's a no-op on a nil autoscaler, so workers can call it unconditionally.
+func (a *autoscaler) observe(status int, latency time.Duration) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.responses++
+	if status == 0 || retryable(status) {
+		a.congested++
+		return
+	}
+	a.latency += latency
+}
+
+// transferred records bytes downloaded.
+func (a *autoscaler) transferred(n int64) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.bytes += n
+	a.mu.Unlock()
+}
+
+// next returns the pool size for the coming interval, given the current
+// size and the length of the interval just observed.
+func (a *autoscaler) next(size int, elapsed time.Duration) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	responses, congested, latency, bytes := a.responses, a.congested, a.latency, a.bytes
+	a.responses, a.congested, a.latency, a.bytes = 0, 0, 0, 0
+
+	clamp := func(n int) int { return min(max(n, a.min), a.max) }
+	if congested > 0 {
+		return clamp(size / 2)
+	}
+	if responses == 0 {
+		return clamp(size)
+	}
+
+	avgLatency := latency / time.Duration(responses)
+	if a.baseLatency == 0 || avgLatency < a.baseLatency {
+		a.baseLatency = avgLatency
+	}
+	if avgLatency > 3*a.baseLatency {
+		return clamp(size / 2)
+	}
+
+	rate := float64(bytes) / elapsed.Seconds()
+	defer func() { a.lastRate = rate }()
+	// More workers only help while they add throughput.
+	if a.lastRate > 0 && rate < 0.95*a.lastRate {
+		return clamp(size)
+	}
+	return clamp(size + 1)
+}
@@ -0,0 +1,127 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/ttomsu/gphotobackup/internal/storage"
+	"github.com/ttomsu/gphotobackup/internal/utils"
+)
+
+const (
+	// BlobDir holds each distinct file once, named by its SHA-256, when
+	// --dedup is set. The date and album trees link to these blobs.
+	BlobDir          = ".blobs"
+	dedupLedgerName  = "dedup.json"
+	dedupIncomingDir = "incoming"
+)
+
+// DedupTotals are the cumulative savings of dedup mode, kept in the blob dir.
+type DedupTotals struct {
+	Items int64 `json:"items"`
+	Bytes int64 `json:"bytes"`
+}
+
+func blobPath(sum string) string {
+	return path.Join(BlobDir, sum[:2], sum)
+}
+
+// writeDedup stores the item as a blob named by its hash, unless an identical
+// blob exists already, and links the item's destination to it.
+func (w *worker) writeDedup(miw *mediaItemWrapper, body io.Reader) error {
+	incoming := path.Join(BlobDir, dedupIncomingDir, utils.Sanitize(miw.src.Id))
+	if err := w.store.MkdirAll(path.Dir(incoming)); err != nil {
+		return errors.Wrap(err, "creating incoming blob dir")
+	}
+	var modTime time.Time
+	if miw.src.MediaMetadata.CreationTime != "" {
+		modTime = miw.creationTime
+	}
+	out, err := w.store.Create(incoming, modTime)
+	if err != nil {
+		return errors.Wrapf(err, "creating item %v", miw.src.Id)
+	}
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(out, hash), body)
+	if err != nil {
+		_ = out.Abort()
+		return errors.Wrapf(err, "writing item %v", miw.src.Id)
+	}
+	if err := out.Close(); err != nil {
+		return errors.Wrapf(err, "committing item %v", miw.src.Id)
+	}
+
+	blob := blobPath(hex.EncodeToString(hash.Sum(nil)))
+	if _, err := w.store.Stat(blob); err == nil {
+		w.itemLogger(miw).Debugw("Duplicate of an existing blob", "blob", blob)
+		_ = w.store.Remove(incoming)
+		w.stats.DedupItems.Add(1)
+		w.stats.DedupBytes.Add(n)
+	} else {
+		if err := w.store.MkdirAll(path.Dir(blob)); err != nil {
+			return errors.Wrap(err, "creating blob dir")
+		}
+		if err := w.store.Rename(incoming, blob); err != nil {
+			return errors.Wrapf(err, "storing blob for %v", miw.src.Id)
+		}
+	}
+	return errors.Wrapf(w.store.Link(blob, miw.destFilepath()), "linking %v", miw.destFilepath())
+}
+
+// ReadDedupTotals returns the savings recorded by previous dedup runs.
+func ReadDedupTotals(store storage.Storage) (*DedupTotals, error) {
+	totals := &DedupTotals{}
+	r, err := store.Open(path.Join(BlobDir, dedupLedgerName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return totals, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return totals, errors.Wrap(json.NewDecoder(r).Decode(totals), "decoding dedup totals")
+}
+
+// recordDedup adds this session's savings to the totals kept in the blob dir.
+func (bs *Session) recordDedup() (*DedupTotals, error) {
+	totals, err := ReadDedupTotals(bs.store)
+	if err != nil {
+		return nil, err
+	}
+	totals.Items += bs.stats.DedupItems.Load()
+	totals.Bytes += bs.stats.DedupBytes.Load()
+
+	data, err := json.MarshalIndent(totals, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	if err := bs.store.MkdirAll(BlobDir); err != nil {
+		return nil, err
+	}
+	out, err := bs.store.Create(path.Join(BlobDir, dedupLedgerName), time.Time{})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := out.Write(data); err != nil {
+		_ = out.Abort()
+		return nil, err
+	}
+	return totals, out.Close()
+}
+
+// ReportDedup logs how much space dedup mode saved in this session and overall.
+func (bs *Session) ReportDedup() {
+	totals, err := bs.recordDedup()
+	if err != nil {
+		bs.logger.Errorf("Error recording dedup totals: %v", err)
+		return
+	}
+	bs.logger.Infof("Dedup saved %v in %v items this run, %v in %v items in total",
+		utils.FormatBytes(bs.stats.DedupBytes.Load()), bs.stats.DedupItems.Load(),
+		utils.FormatBytes(totals.Bytes), totals.Items)
+}
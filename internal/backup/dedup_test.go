@@ -0,0 +1,62 @@
+package backup
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gphotosuploader/googlemirror/api/photoslibrary/v1"
+	"github.com/ttomsu/gphotobackup/internal/storage"
+	"go.uber.org/zap"
+)
+
+func TestWriteDedup(t *testing.T) {
+	store := storage.NewMemory()
+	w := &worker{store: store, stats: &Stats{}, logger: zap.NewNop().Sugar()}
+
+	items := []struct {
+		id, dir, data string
+	}{
+		{id: "one", dir: "2021/05/04", data: "same bytes"},
+		{id: "two", dir: "2021/05/04", data: "same bytes"},
+		{id: "three", dir: "albums/Trip", data: "other bytes"},
+	}
+	for _, item := range items {
+		miw := &mediaItemWrapper{
+			src: &photoslibrary.MediaItem{
+				Id:            item.id,
+				Filename:      item.id + ".jpg",
+				MediaMetadata: &photoslibrary.MediaMetadata{},
+			},
+			destDirName: item.dir,
+			startTime:   time.Now(),
+		}
+		if err := store.MkdirAll(miw.destDir()); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := w.writeDedup(miw, strings.NewReader(item.data)); err != nil {
+			t.Fatalf("writeDedup %v: %v", item.id, err)
+		}
+		if got, err := store.ReadFile(miw.destFilepath()); err != nil || string(got) != item.data {
+			t.Fatalf("expected %v to hold %q, got: %q, %v", miw.destFilepath(), item.data, got, err)
+		}
+	}
+
+	blobs := 0
+	err := storage.Walk(store, BlobDir, func(string, *storage.FileInfo) error {
+		blobs++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+	if blobs != 2 {
+		t.Fatalf("expected 2 blobs, got: %v", blobs)
+	}
+	if got := w.stats.DedupItems.Load(); got != 1 {
+		t.Fatalf("expected 1 deduplicated item, got: %v", got)
+	}
+	if got := w.stats.DedupBytes.Load(); got != int64(len("same bytes")) {
+		t.Fatalf("expected %v bytes saved, got: %v", len("same bytes"), got)
+	}
+}
@@ -0,0 +1,97 @@
+package backup
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/ttomsu/gphotobackup/internal/quota"
+)
+
+// Plan is what a dry run found: the items a backup would download and those
+// already at their destination.
+type Plan struct {
+	New     []PlanItem `json:"new"`
+	Present []PlanItem `json:"present"`
+	// Bytes is the expected size of the new items whose size is known, and
+	// UnknownSize the number of new items whose size isn't.
+	Bytes       int64 `json:"bytes"`
+	UnknownSize int64 `json:"unknownSize"`
+	// Cost is the quota a real run would use.
+	Cost quota.Cost `json:"quotaCost"`
+
+	mu sync.Mutex
+}
+
+// PlanItem is one media item in a Plan.
+type PlanItem struct {
+	ID       string `json:"id"`
+	Path     string `json:"path"`
+	MimeType string `json:"mimeType,omitempty"`
+	Bytes    int64  `json:"bytes,omitempty"`
+	Note     string `json:"note,omitempty"`
+}
+
+func (p *Plan) addNew(item PlanItem) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.New = append(p.New, item)
+	p.Cost.Media++
+	if item.Bytes > 0 {
+		p.Bytes += item.Bytes
+	} else {
+		p.UnknownSize++
+	}
+}
+
+func (p *Plan) addPresent(item PlanItem) {
+	p.mu.Lock()
+	p.Present = append(p.Present, item)
+	p.mu.Unlock()
+}
+
+func (p *Plan) addAPICall() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.Cost.API++
+	p.mu.Unlock()
+}
+
+// plan records where the item would go and whether it's already there,
+// instead of downloading it.
+func (w *worker) plan(miw *mediaItemWrapper) Result {
+	item := PlanItem{ID: miw.src.Id, Path: miw.destFilepath(), MimeType: miw.src.MimeType}
+	if fi, err := w.store.Stat(miw.destFilepath()); err == nil {
+		item.Bytes = fi.Size
+		w.dryRun.addPresent(item)
+		return Skipped
+	}
+	url, err := downloadURL(miw)
+	if err != nil {
+		item.Note = err.Error()
+	} else if w.measure {
+		if item.Bytes, err = w.contentLength(url); err != nil {
+			item.Note = err.Error()
+		}
+	}
+	w.dryRun.addNew(item)
+	return Downloaded
+}
+
+// contentLength asks for the size of a download without fetching it.
+func (w *worker) contentLength(url string) (int64, error) {
+	resp, err := w.client.Head(url)
+	if err != nil {
+		return 0, errors.Wrap(err, "getting size")
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.Errorf("getting size: %v", resp.Status)
+	}
+	if resp.ContentLength < 0 {
+		return 0, errors.New("size not reported")
+	}
+	return resp.ContentLength, nil
+}
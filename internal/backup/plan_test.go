@@ -0,0 +1,90 @@
+package backup
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gphotosuploader/googlemirror/api/photoslibrary/v1"
+	"github.com/ttomsu/gphotobackup/internal/storage"
+	"go.uber.org/zap"
+)
+
+func TestDryRunPlan(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodHead {
+			t.Errorf("dry run made a %v request", r.Method)
+		}
+		w.Header().Set("Content-Length", "1234")
+	}))
+	defer ts.Close()
+
+	store := storage.NewMemory()
+	present := &mediaItemWrapper{
+		src: &photoslibrary.MediaItem{
+			Id:            "old",
+			Filename:      "old.jpg",
+			BaseUrl:       ts.URL + "/old",
+			MediaMetadata: &photoslibrary.MediaMetadata{Photo: &photoslibrary.Photo{}},
+		},
+		destDirName: "2021/05/04",
+	}
+	if err := store.MkdirAll(present.destDir()); err != nil {
+		t.Fatal(err)
+	}
+	out, err := store.Create(present.destFilepath(), time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = out.Write([]byte("old"))
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	plan := &Plan{}
+	w := &worker{ctx: context.Background(), mu: &sync.Mutex{}, client: ts.Client(), store: store, stats: &Stats{},
+		dryRun: plan, measure: true, logger: zap.NewNop().Sugar()}
+	items := []*mediaItemWrapper{
+		present,
+		{
+			src: &photoslibrary.MediaItem{
+				Id:            "new",
+				Filename:      "new.jpg",
+				BaseUrl:       ts.URL + "/new",
+				MediaMetadata: &photoslibrary.MediaMetadata{Photo: &photoslibrary.Photo{}},
+			},
+			destDirName: "2021/05/04",
+		},
+		{
+			src: &photoslibrary.MediaItem{
+				Id:            "pending",
+				Filename:      "pending.mp4",
+				BaseUrl:       ts.URL + "/pending",
+				MediaMetadata: &photoslibrary.MediaMetadata{Video: &photoslibrary.Video{Status: "PROCESSING"}},
+			},
+			destDirName: "2021/05/04",
+		},
+	}
+	want := []Result{Skipped, Downloaded, Downloaded}
+	for i, miw := range items {
+		if got := w.process(miw); got != want[i] {
+			t.Errorf("%v: result %v, want %v", miw.src.Id, got, want[i])
+		}
+	}
+
+	if len(plan.Present) != 1 || plan.Present[0].Bytes != 3 {
+		t.Errorf("present = %+v, want the old item", plan.Present)
+	}
+	if len(plan.New) != 2 || plan.Bytes != 1234 || plan.UnknownSize != 1 {
+		t.Errorf("new = %+v, bytes %v, unknown %v", plan.New, plan.Bytes, plan.UnknownSize)
+	}
+	if plan.Cost.Media != 2 {
+		t.Errorf("media cost = %v, want 2", plan.Cost.Media)
+	}
+	if _, err := store.Stat(items[1].destFilepath()); err == nil {
+		t.Error("dry run wrote the new item")
+	}
+}
@@ -0,0 +1,103 @@
+package backup
+
+import (
+	"sync"
+
+	"github.com/ttomsu/gphotobackup/internal/metrics"
+)
+
+// pool runs workers that take items from a queue. It can be resized while
+// running: extra workers exit once they finish their current item.
+type pool struct {
+	queue     <-chan *mediaItemWrapper
+	newWorker func(id int) *worker
+
+	mu      sync.Mutex
+	size    int
+	target  int
+	nextID  int
+	wake    chan struct{}
+	done    chan struct{}
+	running sync.WaitGroup
+}
+
+func newPool(queue <-chan *mediaItemWrapper, newWorker func(id int) *worker) *pool {
+	return &pool{queue: queue, newWorker: newWorker}
+}
+
+// start runs n workers until stop.
+func (p *pool) start(n int) {
+	p.mu.Lock()
+	p.done = make(chan struct{})
+	p.wake = make(chan struct{})
+	p.mu.Unlock()
+	p.resize(n)
+}
+
+// resize grows or shrinks the pool to n workers, but never below one.
+func (p *pool) resize(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.target = max(n, 1)
+	metrics.Workers.Set(float64(p.target))
+	for p.size < p.target {
+		p.size++
+		p.running.Add(1)
+		go p.run(p.newWorker(p.nextID))
+		p.nextID++
+	}
+	if p.size > p.target {
+		// Wake idle workers so they notice they're surplus.
+		close(p.wake)
+		p.wake = make(chan struct{})
+	}
+}
+
+// Size is the number of workers the pool is heading to.
+func (p *pool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.target
+}
+
+// stop waits for every worker to finish its current item and exit.
+func (p *pool) stop() {
+	p.mu.Lock()
+	close(p.done)
+	p.mu.Unlock()
+	p.running.Wait()
+	p.mu.Lock()
+	p.size, p.target = 0, 0
+	p.mu.Unlock()
+	metrics.Workers.Set(0)
+}
+
+// surplus claims an exit if the pool is over its target size.
+func (p *pool) surplus() (bool, chan struct{}) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.size > p.target {
+		p.size--
+		return true, nil
+	}
+	return false, p.wake
+}
+
+func (p *pool) run(w *worker) {
+	defer p.running.Done()
+	for {
+		exit, wake := p.surplus()
+		if exit {
+			w.logger.Debugf("Worker %v is surplus, stopping", w.id)
+			return
+		}
+		select {
+		case miw := <-p.queue:
+			w.handle(miw)
+		case <-wake:
+		case <-p.done:
+			w.logger.Debugf("Worker %v received stop signal", w.id)
+			return
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gphotosuploader/googlemirror/api/photoslibrary/v1"
+	"github.com/ttomsu/gphotobackup/internal/storage"
+	"go.uber.org/zap"
+)
+
+func TestPoolResize(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Millisecond)
+		_, _ = w.Write([]byte("photo bytes"))
+	}))
+	defer ts.Close()
+
+	queue := make(chan *mediaItemWrapper)
+	wg := &sync.WaitGroup{}
+	stats := &Stats{}
+	store := storage.NewMemory()
+	p := newPool(queue, func(id int) *worker {
+		return &worker{id: id, ctx: context.Background(), wg: wg, mu: &sync.Mutex{}, client: ts.Client(), store: store,
+			stats: stats, progress: noProgress{}, logger: zap.NewNop().Sugar()}
+	})
+
+	p.start(2)
+	const items = 60
+	for i := 0; i < items; i++ {
+		switch i {
+		case 10:
+			p.resize(8)
+		case 30:
+			p.resize(0)
+		case 45:
+			p.resize(3)
+		}
+		wg.Add(1)
+		queue <- &mediaItemWrapper{
+			src: &photoslibrary.MediaItem{
+				Id:            fmt.Sprint(i),
+				Filename:      fmt.Sprintf("%v.jpg", i),
+				BaseUrl:       fmt.Sprintf("%v/%v", ts.URL, i),
+				MediaMetadata: &photoslibrary.MediaMetadata{Photo: &photoslibrary.Photo{}},
+			},
+			destDirName: "2021/05/04",
+			startTime:   time.Now(),
+		}
+	}
+	wg.Wait()
+	if got := p.Size(); got != 3 {
+		t.Errorf("size = %v, want 3", got)
+	}
+	p.stop()
+
+	if got := stats.Downloaded.Load(); got != items {
+		t.Errorf("downloaded = %v, want %v", got, items)
+	}
+}
+
+func TestAutoscalerNext(t *testing.T) {
+	a := newAutoscaler(2, 6)
+	interval := time.Second
+	good := func() {
+		a.observe(http.StatusOK, 100*time.Millisecond)
+		a.transferred(1 << 20)
+	}
+
+	good()
+	if got := a.next(2, interval); got != 3 {
+		t.Errorf("after a good interval: %v, want 3", got)
+	}
+	good()
+	a.observe(http.StatusTooManyRequests, 0)
+	if got := a.next(5, interval); got != 2 {
+		t.Errorf("after a 429: %v, want 2", got)
+	}
+	a.observe(http.StatusOK, time.Second)
+	if got := a.next(4, interval); got != 2 {
+		t.Errorf("after a latency spike: %v, want 2", got)
+	}
+	if got := a.next(4, interval); got != 4 {
+		t.Errorf("with no responses: %v, want 4", got)
+	}
+	good()
+	if got := a.next(6, interval); got != 6 {
+		t.Errorf("at the maximum: %v, want 6", got)
+	}
+	a.observe(http.StatusOK, 100*time.Millisecond)
+	a.transferred(1 << 10)
+	if got := a.next(5, interval); got != 5 {
+		t.Errorf("after throughput dropped: %v, want 5", got)
+	}
+}
@@ -0,0 +1,32 @@
+package backup
+
+// Progress is told about items as a Session finds and processes them. The
+// workers call it concurrently.
+type Progress interface {
+	// Discovered is called as each page of search results is queued.
+	Discovered(n int)
+	// Started is called when a worker picks up an item.
+	Started(worker int, name string)
+	// Transferred is called as the worker downloads the item's bytes.
+	Transferred(worker int, n int64)
+	// Finished is called when the worker is done with the item.
+	Finished(worker int, result Result)
+}
+
+// Result is what happened to an item.
+type Result int
+
+const (
+	Downloaded Result = iota
+	Skipped
+	Failed
+	// Deferred items weren't tried because the daily quota ran out.
+	Deferred
+)
+
+type noProgress struct{}
+
+func (noProgress) Discovered(int)         {}
+func (noProgress) Started(int, string)    {}
+func (noProgress) Transferred(int, int64) {}
+func (noProgress) Finished(int, Result)   {}
@@ -2,68 +2,138 @@ package backup
 
 import (
 	"context"
+	"errors"
+	"github.com/ttomsu/gphotobackup/internal"
+	"github.com/ttomsu/gphotobackup/internal/metrics"
+	"github.com/ttomsu/gphotobackup/internal/quota"
+	"github.com/ttomsu/gphotobackup/internal/storage"
+	"github.com/ttomsu/gphotobackup/internal/throttle"
 	"github.com/ttomsu/gphotobackup/internal/utils"
 	"go.uber.org/zap"
 	"net/http"
-	"os"
-	"path/filepath"
+	"path"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/gphotosuploader/googlemirror/api/photoslibrary/v1"
+	"github.com/spf13/viper"
 )
 
 type Session struct {
-	svc         *photoslibrary.Service
-	queue       chan *mediaItemWrapper
-	wg          *sync.WaitGroup
-	baseDestDir string
-	workers     []*worker
-	logger      *zap.SugaredLogger
+	svc      *photoslibrary.Service
+	client   *http.Client
+	queue    chan *mediaItemWrapper
+	wg       *sync.WaitGroup
+	mu       *sync.Mutex
+	store    storage.Storage
+	pool     *pool
+	workers  int
+	stats    *Stats
+	progress Progress
+	limiter  *throttle.Limiter
+	scaler   *autoscaler
+	dryRun   *Plan
+	measure  bool
+	logger   *zap.SugaredLogger
+
+	// run is cancelled to stop the session. ctx follows it, and is also
+	// cancelled with quota.ErrExhausted once the daily quota runs out, to stop
+	// searching.
+	run  context.Context
+	ctx  context.Context
+	stop context.CancelCauseFunc
 }
 
-func NewSession(client *http.Client, baseDestDir string, workerCount int, logger *zap.SugaredLogger) (*Session, error) {
-	svc, err := photoslibrary.New(client)
+func NewSession(client *http.Client, store storage.Storage, workerCount int, logger *zap.SugaredLogger) (*Session, error) {
+	svc, err := internal.NewPhotosService(client)
 	if err != nil {
 		return nil, err
 	}
 
-	wg := &sync.WaitGroup{}
-	mu := &sync.Mutex{}
-
-	workers := make([]*worker, workerCount)
-	for i := 0; i < workerCount; i++ {
-		workers[i] = &worker{
-			id:     i,
-			stop:   make(chan bool),
-			wg:     wg,
-			mu:     mu,
-			client: client,
-			logger: logger,
-		}
+	logger.Infoln("Starting new backup session...")
+	bs := &Session{
+		svc:      svc,
+		client:   client,
+		queue:    make(chan *mediaItemWrapper, 100),
+		wg:       &sync.WaitGroup{},
+		mu:       &sync.Mutex{},
+		store:    store,
+		workers:  workerCount,
+		stats:    &Stats{},
+		progress: noProgress{},
+		logger:   logger,
 	}
+	bs.SetContext(context.Background())
+	bs.pool = newPool(bs.queue, bs.newWorker)
+	return bs, nil
+}
 
-	logger.Infoln("Starting new backup session...")
-	return &Session{
-		svc:         svc,
-		queue:       make(chan *mediaItemWrapper, 100),
-		wg:          wg,
-		baseDestDir: baseDestDir,
-		workers:     workers,
-		logger:      logger,
-	}, nil
+func (bs *Session) newWorker(id int) *worker {
+	return &worker{
+		id:       id,
+		wg:       bs.wg,
+		mu:       bs.mu,
+		client:   bs.client,
+		store:    bs.store,
+		stats:    bs.stats,
+		progress: bs.progress,
+		limiter:  bs.limiter,
+		scaler:   bs.scaler,
+		ctx:      bs.run,
+		quotaOut: bs.exhausted,
+		dryRun:   bs.dryRun,
+		measure:  bs.measure,
+		logger:   bs.logger,
+	}
 }
 
-func (bs *Session) Start(searchReq *photoslibrary.SearchMediaItemsRequest) {
+// exhausted stops searching once the daily quota runs out.
+func (bs *Session) exhausted() {
+	bs.stop(quota.ErrExhausted)
+}
+
+// AutoScale resizes the worker pool between lo and hi workers based on
+// throughput, latency and errors, starting from lo. It must be called
+// before any of the Start methods.
+func (bs *Session) AutoScale(lo, hi int) {
+	bs.scaler = newAutoscaler(lo, hi)
+	bs.workers = bs.scaler.min
+}
+
+// autoScale resizes the pool every scaleInterval until done is closed.
+func (bs *Session) autoScale(done <-chan struct{}) {
+	ticker := time.NewTicker(scaleInterval)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case now := <-ticker.C:
+			size := bs.pool.Size()
+			if next := bs.scaler.next(size, now.Sub(last)); next != size {
+				bs.logger.Infof("Resizing from %v to %v workers", size, next)
+				bs.pool.resize(next)
+				bs.workers = next
+			}
+			last = now
+		case <-done:
+			return
+		}
+	}
+}
+
+func (bs *Session) Start(searchReq *photoslibrary.SearchMediaItemsRequest) error {
 	bs.logger.Infof("~~~ Starting to backup recent photos...")
-	bs.startInternal(searchReq, "", nil)
+	return bs.startInternal(searchReq, "", nil)
 }
 
-func (bs *Session) StartAlbums() {
+func (bs *Session) StartAlbums() error {
 	bs.logger.Info("~~~ Starting to back up albums...")
-	err := bs.svc.Albums.List().Pages(context.Background(), func(resp *photoslibrary.ListAlbumsResponse) error {
+	err := bs.svc.Albums.List().Pages(bs.ctx, func(resp *photoslibrary.ListAlbumsResponse) error {
+		metrics.APICalls.Inc()
+		bs.dryRun.addAPICall()
 		for _, album := range resp.Albums {
-			albumPath := filepath.Join("albums", utils.Sanitize(album.Title))
+			albumPath := path.Join("albums", utils.Sanitize(album.Title))
 			existingFiles := bs.existingFiles(albumPath)
 
 			if len(existingFiles) == int(album.TotalMediaItems) {
@@ -76,22 +146,28 @@ func (bs *Session) StartAlbums() {
 				PageSize: 100,
 				AlbumId:  album.Id,
 			}
-			bs.startInternal(searchReq, albumPath, existingFiles)
+			if err := bs.startInternal(searchReq, albumPath, existingFiles); err != nil {
+				return err
+			}
 
 			for filename, inAlbum := range existingFiles {
 				if !inAlbum {
-					bs.logger.Warnf("Extra file found: %v", filepath.Join(albumPath, filename))
+					bs.logger.Warnf("Extra file found: %v", path.Join(albumPath, filename))
 				}
 			}
 		}
 		return nil
 	})
-	if err != nil {
+	if cause := context.Cause(bs.ctx); cause != nil {
+		return cause
+	}
+	if err != nil && !errors.Is(err, quota.ErrExhausted) {
 		bs.logger.Errorf("Albums error: %v", err)
 	}
+	return err
 }
 
-func (bs *Session) StartFavorites() {
+func (bs *Session) StartFavorites() error {
 	bs.logger.Info("~~~ Starting to back up favorites...")
 	dirName := "favorites"
 	existingFiles := bs.existingFiles(dirName)
@@ -107,24 +183,38 @@ func (bs *Session) StartFavorites() {
 		},
 	}
 
-	bs.startInternal(searchReq, dirName, existingFiles)
+	return bs.startInternal(searchReq, dirName, existingFiles)
 }
 
-func (bs *Session) startInternal(searchReq *photoslibrary.SearchMediaItemsRequest, destDir string, existingFiles map[string]bool) {
-	for _, w := range bs.workers {
-		go w.start(bs.queue)
-	}
+func (bs *Session) startInternal(searchReq *photoslibrary.SearchMediaItemsRequest, destDir string, existingFiles map[string]bool) error {
+	bs.pool.start(bs.workers)
 	defer bs.Stop()
+	if bs.scaler != nil {
+		done, stopped := make(chan struct{}), make(chan struct{})
+		go func() {
+			bs.autoScale(done)
+			close(stopped)
+		}()
+		defer func() {
+			close(done)
+			<-stopped
+		}()
+	}
 
 	totalCount := 0
 	err := bs.svc.MediaItems.Search(searchReq).
-		Pages(context.Background(), func(resp *photoslibrary.SearchMediaItemsResponse) error {
+		Pages(bs.ctx, func(resp *photoslibrary.SearchMediaItemsResponse) error {
+			metrics.APICalls.Inc()
+			bs.dryRun.addAPICall()
 			count := len(resp.MediaItems)
-			bs.wg.Add(count)
 			totalCount = totalCount + count
 			bs.logger.Infof("Adding %v items to queue (%v)", count, totalCount)
+			bs.progress.Discovered(count)
 
 			for _, item := range resp.MediaItems {
+				if cause := context.Cause(bs.ctx); cause != nil {
+					return cause
+				}
 				miw := bs.wrap(item, destDir)
 				if existingFiles != nil {
 					fullFilename := miw.filename(false)
@@ -135,41 +225,80 @@ func (bs *Session) startInternal(searchReq *photoslibrary.SearchMediaItemsReques
 						existingFiles[fullFilename] = true
 					}
 				}
+				metrics.QueueDepth.Inc()
+				bs.wg.Add(1)
 				bs.queue <- miw
 			}
 			return nil
 		})
-	if err != nil {
+	bs.wg.Wait()
+	if errors.Is(err, quota.ErrExhausted) {
+		bs.exhausted()
+	}
+	if cause := context.Cause(bs.ctx); cause != nil {
+		err = cause
+	}
+	switch {
+	case errors.Is(err, quota.ErrExhausted):
+		bs.logger.Warnf("Daily quota exhausted, %v items left for the next run", bs.stats.Deferred.Load())
+	case errors.Is(err, context.Canceled):
+		bs.logger.Warnf("Stopped, %v items left for the next run", bs.stats.Deferred.Load())
+	case err != nil:
 		bs.logger.Errorf("Search error: %v", err)
 	}
-	bs.wg.Wait()
+	return err
 }
 
 func (bs *Session) Stop() {
-	bs.wg.Add(len(bs.workers))
-	for _, w := range bs.workers {
-		w.stop <- true
-	}
-	bs.wg.Wait()
+	bs.pool.stop()
+}
+
+// SetContext stops the session when ctx is done: searching stops, queued
+// items are left for the next run and downloads in progress are abandoned.
+// It must be called before any of the Start methods.
+func (bs *Session) SetContext(ctx context.Context) {
+	bs.run = ctx
+	bs.ctx, bs.stop = context.WithCancelCause(ctx)
+}
+
+// SetProgress reports the session's progress to p. It must be called before
+// any of the Start methods.
+func (bs *Session) SetProgress(p Progress) {
+	bs.progress = p
+}
+
+// SetLimiter shares l between the workers to limit their download rate. It
+// must be called before any of the Start methods.
+func (bs *Session) SetLimiter(l *throttle.Limiter) {
+	bs.limiter = l
+}
+
+// SetDryRun makes the workers record what they would download in plan
+// instead of downloading it. With measure, they ask for the size of each new
+// item, which counts against the media quota. It must be called before any
+// of the Start methods.
+func (bs *Session) SetDryRun(plan *Plan, measure bool) {
+	bs.dryRun = plan
+	bs.measure = measure
+}
+
+// Stats returns the session's counters.
+func (bs *Session) Stats() *Stats {
+	return bs.stats
 }
 
 func (bs *Session) existingFiles(dir string) map[string]bool {
 	m := make(map[string]bool)
-	fullDir := filepath.Join(bs.baseDestDir, dir)
-	f, err := os.Open(fullDir)
+	list, err := bs.store.List(dir)
 	if err != nil {
-		bs.logger.Errorf("error opening fullDir %v to count: %v", fullDir, err)
-		return m
-	}
-	list, err := f.Readdirnames(-1)
-	_ = f.Close()
-	if err != nil {
-		bs.logger.Errorf("error reading dirnames: %v", err)
+		bs.logger.Errorf("error listing dir %v to count: %v", dir, err)
 		return m
 	}
 	m = make(map[string]bool, len(list))
 	for _, filename := range list {
-		m[filename] = false
+		if !strings.HasSuffix(filename, SidecarSuffix) {
+			m[filename] = false
+		}
 	}
 	return m
 }
@@ -181,9 +310,10 @@ func (bs *Session) wrap(mi *photoslibrary.MediaItem, destDirName string) *mediaI
 	}
 	return &mediaItemWrapper{
 		src:          mi,
-		baseDestDir:  bs.baseDestDir,
 		creationTime: t,
 		startTime:    time.Now(),
 		destDirName:  destDirName,
+		obfuscate:    viper.GetBool("obfuscate-names"),
+		sidecar:      viper.GetBool("obfuscate-names") || viper.GetBool("sidecars"),
 	}
 }
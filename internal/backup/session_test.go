@@ -0,0 +1,200 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gphotosuploader/googlemirror/api/photoslibrary/v1"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/ttomsu/gphotobackup/internal/fakephotos"
+	"github.com/ttomsu/gphotobackup/internal/quota"
+	"github.com/ttomsu/gphotobackup/internal/storage"
+	"go.uber.org/zap"
+)
+
+func TestSessionEndToEnd(t *testing.T) {
+	retryDelay = time.Millisecond
+	defer func() { retryDelay = time.Second }()
+
+	fake := fakephotos.New()
+	defer fake.Close()
+	fake.PageSize = 2
+	viper.Set("api.endpoint", fake.Endpoint())
+	defer viper.Set("api.endpoint", nil)
+
+	day := time.Date(2021, 5, 4, 12, 0, 0, 0, time.Local)
+	fake.Add(
+		fakephotos.Item{ID: "a", Filename: "a.jpg", Created: day, Data: []byte("photo a")},
+		fakephotos.Item{ID: "b", Filename: "b.jpg", Created: day, Data: []byte("photo b"), Favorite: true},
+		fakephotos.Item{ID: "c", Filename: "c.mp4", Created: day, Data: []byte("video c"), Video: true},
+		fakephotos.Item{ID: "d", Filename: "d.mp4", Created: day, Data: []byte("video d"), Video: true, Status: "PROCESSING"},
+		fakephotos.Item{ID: "e", Filename: "e.jpg", Created: day.AddDate(0, 0, 1), Data: []byte("photo e")},
+	)
+	fake.AddAlbum("trip", "Road trip", false, "a", "e")
+	fake.AddAlbum("friends", "Friends", true, "b")
+	fake.FailDownloads("a", 2)
+
+	store := storage.NewMemory()
+	run := func() *Stats {
+		t.Helper()
+		bs, err := NewSession(fake.Client(), store, 2, zap.NewNop().Sugar())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := bs.Start(&photoslibrary.SearchMediaItemsRequest{PageSize: 100}); err != nil {
+			t.Fatal(err)
+		}
+		if err := bs.StartFavorites(); err != nil {
+			t.Fatal(err)
+		}
+		if err := bs.StartAlbums(); err != nil {
+			t.Fatal(err)
+		}
+		return bs.Stats()
+	}
+
+	stats := run()
+	want := map[string]string{
+		"2021/05/04/a-a.jpg":       "photo a",
+		"2021/05/04/b-b.jpg":       "photo b",
+		"2021/05/04/c-c.mp4":       "video c",
+		"2021/05/05/e-e.jpg":       "photo e",
+		"favorites/b-b.jpg":        "photo b",
+		"albums/Road_trip/a-a.jpg": "photo a",
+		"albums/Road_trip/e-e.jpg": "photo e",
+	}
+	for name, data := range want {
+		if got, err := store.ReadFile(name); err != nil || string(got) != data {
+			t.Errorf("%v = %q, %v, want %q", name, got, err, data)
+		}
+	}
+	if got := stats.Downloaded.Load(); got != int64(len(want)) {
+		t.Errorf("downloaded = %v, want %v", got, len(want))
+	}
+	if got := stats.Failed.Load(); got != 1 {
+		t.Errorf("failed = %v, want 1 for the unprocessed video", got)
+	}
+	if got := fake.Requests("search"); got != 5 {
+		t.Errorf("searches = %v, want 5 for 3 pages, favorites and the album", got)
+	}
+
+	// A second run only picks up the video that has finished processing, and
+	// gets fresh URLs for it.
+	fake.ExpireURLs()
+	fake.SetStatus("d", "READY")
+	stats = run()
+	if got, err := store.ReadFile("2021/05/04/d-d.mp4"); err != nil || string(got) != "video d" {
+		t.Errorf("d-d.mp4 = %q, %v", got, err)
+	}
+	if got := stats.Downloaded.Load(); got != 1 {
+		t.Errorf("downloaded on second run = %v, want 1", got)
+	}
+	// The album already has all its items, so it isn't searched again.
+	if got := stats.Skipped.Load(); got != 5 {
+		t.Errorf("skipped on second run = %v, want 5", got)
+	}
+
+	fake.FailAPI(1)
+	bs, err := NewSession(fake.Client(), store, 2, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Start(&photoslibrary.SearchMediaItemsRequest{PageSize: 100}); err == nil {
+		t.Error("expected a 429 from search to fail the run")
+	}
+}
+
+func TestFakeExpiredURL(t *testing.T) {
+	fake := fakephotos.New()
+	defer fake.Close()
+	viper.Set("api.endpoint", fake.Endpoint())
+	defer viper.Set("api.endpoint", nil)
+	fake.Add(fakephotos.Item{ID: "a", Filename: "a.jpg", Created: time.Now(), Data: []byte("photo a")})
+
+	bs, err := NewSession(fake.Client(), storage.NewMemory(), 1, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mi, err := bs.svc.MediaItems.Get("a").Do()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake.ExpireURLs()
+	resp, err := fake.Client().Get(mi.BaseUrl + "=d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expired URL returned %v, want 403", resp.Status)
+	}
+}
+
+func TestSessionStopsSearchingWhenQuotaRunsOut(t *testing.T) {
+	fake := fakephotos.New()
+	defer fake.Close()
+	fake.PageSize = 1
+	viper.Set("api.endpoint", fake.Endpoint())
+	defer viper.Set("api.endpoint", nil)
+	const items = 20
+	for i := 0; i < items; i++ {
+		id := fmt.Sprint(i)
+		fake.Add(fakephotos.Item{ID: id, Filename: id + ".jpg", Created: time.Now(), Data: []byte(id)})
+	}
+	fake.AddAlbum("all", "All", false, "0", "1")
+
+	tracker, err := quota.Open(t.TempDir(), quota.Limits{Media: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &quota.Transport{Base: fake.Client().Transport, Tracker: tracker}}
+	bs, err := NewSession(client, storage.NewMemory(), 1, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bs.Start(&photoslibrary.SearchMediaItemsRequest{PageSize: 100}); !errors.Is(err, quota.ErrExhausted) {
+		t.Fatalf("expected the quota to run out, got %v", err)
+	}
+	if got := fake.Requests("search"); got >= items {
+		t.Errorf("searched all %v pages after the media quota ran out", got)
+	}
+	if err := bs.StartAlbums(); !errors.Is(err, quota.ErrExhausted) {
+		t.Errorf("expected albums to stop at once, got %v", err)
+	}
+	if got := fake.Requests("albums"); got != 0 {
+		t.Errorf("listed albums %v times after the quota ran out", got)
+	}
+}
+
+func TestSessionStopsWhenCancelled(t *testing.T) {
+	fake := fakephotos.New()
+	defer fake.Close()
+	fake.PageSize = 1
+	viper.Set("api.endpoint", fake.Endpoint())
+	defer viper.Set("api.endpoint", nil)
+	for i := 0; i < 5; i++ {
+		id := fmt.Sprint(i)
+		fake.Add(fakephotos.Item{ID: id, Filename: id + ".jpg", Created: time.Now(), Data: []byte(id)})
+	}
+
+	bs, err := NewSession(fake.Client(), storage.NewMemory(), 1, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	bs.SetContext(ctx)
+	cancel()
+	if err := bs.Start(&photoslibrary.SearchMediaItemsRequest{PageSize: 100}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the run to be cancelled, got %v", err)
+	}
+	if got := bs.Stats().Downloaded.Load(); got != 0 {
+		t.Errorf("downloaded %v items after the run was cancelled", got)
+	}
+	if err := bs.StartAlbums(); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected albums to stop at once, got %v", err)
+	}
+}
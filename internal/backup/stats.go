@@ -0,0 +1,29 @@
+package backup
+
+import "sync/atomic"
+
+// Stats are counters shared by a Session and its workers.
+type Stats struct {
+	Downloaded atomic.Int64
+	Skipped    atomic.Int64
+	Failed     atomic.Int64
+	Bytes      atomic.Int64
+	// Deferred counts items left for the next day's quota.
+	Deferred atomic.Int64
+
+	// DedupItems and DedupBytes count downloads that matched an existing blob
+	// in dedup mode, and so took no extra space.
+	DedupItems atomic.Int64
+	DedupBytes atomic.Int64
+}
+
+// Add adds the counts in o to s.
+func (s *Stats) Add(o *Stats) {
+	s.Downloaded.Add(o.Downloaded.Load())
+	s.Skipped.Add(o.Skipped.Load())
+	s.Failed.Add(o.Failed.Load())
+	s.Bytes.Add(o.Bytes.Load())
+	s.Deferred.Add(o.Deferred.Load())
+	s.DedupItems.Add(o.DedupItems.Load())
+	s.DedupBytes.Add(o.DedupBytes.Load())
+}
@@ -1,110 +1,287 @@
 package backup
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/gphotosuploader/googlemirror/api/photoslibrary/v1"
 	"github.com/pkg/errors"
 	"github.com/spf13/viper"
+	"github.com/ttomsu/gphotobackup/internal/metrics"
+	"github.com/ttomsu/gphotobackup/internal/quota"
+	"github.com/ttomsu/gphotobackup/internal/storage"
+	"github.com/ttomsu/gphotobackup/internal/throttle"
 	"go.uber.org/zap"
 )
 
 type worker struct {
-	id     int
-	stop   chan bool
-	wg     *sync.WaitGroup
-	mu     *sync.Mutex
-	client *http.Client
-	logger *zap.SugaredLogger
+	id       int
+	wg       *sync.WaitGroup
+	mu       *sync.Mutex
+	client   *http.Client
+	store    storage.Storage
+	stats    *Stats
+	progress Progress
+	limiter  *throttle.Limiter
+	scaler   *autoscaler
+	ctx      context.Context
+	quotaOut func()
+	dryRun   *Plan
+	measure  bool
+	logger   *zap.SugaredLogger
 }
 
-func (w *worker) start(queue <-chan *mediaItemWrapper) {
-	for {
-		select {
-		case miw := <-queue:
-			if viper.GetBool("verbose") {
-				w.logger.Debugf("Worker %v got %v of size %vw x %vh created at %v", w.id, miw.src.MimeType, miw.src.MediaMetadata.Width, miw.src.MediaMetadata.Height, miw.src.MediaMetadata.CreationTime)
-			}
+// handle processes an item taken from the queue.
+func (w *worker) handle(miw *mediaItemWrapper) {
+	metrics.QueueDepth.Dec()
+	w.progress.Started(w.id, miw.destFilepathShort())
+	w.progress.Finished(w.id, w.process(miw))
+	w.wg.Done()
+}
 
-			err := w.ensureDestExists(miw)
-			if err != nil {
-				w.logger.Errorf("Error creating dest for %v, err: %v", miw.src.Filename, err)
-				w.wg.Done()
-				continue
-			}
-			if !w.fileExists(miw.destFilepath()) {
-				data, err := w.fetchItem(miw)
-				if err != nil {
-					w.logger.Errorf("Error fetching %v, err: %v", miw.src.Filename, err)
-					w.wg.Done()
-					continue
-				}
-				if err = w.writeItem(miw, data); err != nil {
-					w.logger.Errorf("Error writing %v, err: %v", miw.destFilepath(), err)
-					w.wg.Done()
-					continue
-				}
-			} else {
-				if viper.GetBool("verbose") {
-					w.logger.Debugf("%v already exists", miw.destFilepathShort())
-				}
-			}
-			w.wg.Done()
-		case <-w.stop:
-			w.logger.Debugf("Worker %v received stop signal", w.id)
-			w.wg.Done()
-			return
+// process downloads one item unless it's already at its destination.
+func (w *worker) process(miw *mediaItemWrapper) Result {
+	log := w.itemLogger(miw)
+	log.Debugw("Processing item",
+		"mimeType", miw.src.MimeType,
+		"width", miw.src.MediaMetadata.Width,
+		"height", miw.src.MediaMetadata.Height,
+		"created", miw.src.MediaMetadata.CreationTime)
+	if w.ctx.Err() != nil {
+		w.stats.Deferred.Add(1)
+		return Deferred
+	}
+	if w.dryRun != nil {
+		return w.plan(miw)
+	}
+
+	err := w.ensureDestExists(miw)
+	if err != nil {
+		log.Errorw("Error creating dest", "error", err)
+		return w.failed()
+	}
+	if w.fileExists(miw.destFilepath()) {
+		log.Debugw("Already exists", "path", miw.destFilepathShort())
+		metrics.ItemsSkipped.Inc()
+		w.stats.Skipped.Add(1)
+		return Skipped
+	}
+
+	start := time.Now()
+	body, err := w.fetchItem(miw)
+	if errors.Is(err, quota.ErrExhausted) {
+		w.stats.Deferred.Add(1)
+		if w.quotaOut != nil {
+			w.quotaOut()
 		}
+		return Deferred
+	} else if err != nil {
+		log.Errorw("Error fetching", "filename", miw.src.Filename, "error", err)
+		return w.failed()
+	}
+	var src io.Reader = body
+	if w.limiter != nil {
+		src = w.limiter.Reader(w.ctx, body)
+	}
+	counted := &countingReader{r: src, progress: w.progress, worker: w.id}
+	if viper.GetBool("dedup") {
+		err = w.writeDedup(miw, counted)
+	} else {
+		err = w.writeItem(miw, counted)
+	}
+	_ = body.Close()
+	metrics.BytesDownloaded.Add(float64(counted.n))
+	w.stats.Bytes.Add(counted.n)
+	w.scaler.transferred(counted.n)
+	if err != nil {
+		log.Errorw("Error writing", "path", miw.destFilepath(), "bytes", counted.n, "error", err)
+		return w.failed()
 	}
+	duration := time.Since(start)
+	metrics.ItemsDownloaded.Inc()
+	w.stats.Downloaded.Add(1)
+	metrics.DownloadDuration.Observe(duration.Seconds())
+	log.Infow("Downloaded", "path", miw.destFilepathShort(), "bytes", counted.n, "duration", duration)
+
+	if miw.sidecar {
+		if err = w.writeSidecar(miw); err != nil {
+			log.Errorw("Error writing sidecar", "path", miw.destFilepath(), "error", err)
+		}
+	}
+	return Downloaded
+}
+
+// itemLogger adds fields identifying the item and worker to every line.
+func (w *worker) itemLogger(miw *mediaItemWrapper) *zap.SugaredLogger {
+	log := w.logger.With("item", miw.src.Id, "worker", w.id)
+	if miw.destDirName != "" {
+		log = log.With("album", miw.destDirName)
+	}
+	return log
+}
+
+func (w *worker) failed() Result {
+	metrics.ItemsFailed.Inc()
+	w.stats.Failed.Add(1)
+	return Failed
 }
 
 func (w *worker) ensureDestExists(miw *mediaItemWrapper) error {
 	w.mu.Lock()
-	err := os.MkdirAll(miw.destDir(), 0755)
+	err := w.store.MkdirAll(miw.destDir())
 	w.mu.Unlock()
 	return errors.Wrapf(err, "error creating dest dir %v", miw.destDir())
 }
 
 func (w *worker) fileExists(destFilename string) bool {
-	_, err := os.Stat(destFilename)
+	_, err := w.store.Stat(destFilename)
 	return err == nil
 }
 
-func (w *worker) fetchItem(miw *mediaItemWrapper) ([]byte, error) {
-	var url string
+// downloadURL is where the item's original bytes can be fetched from.
+func downloadURL(miw *mediaItemWrapper) (string, error) {
 	switch {
 	case miw.src.MediaMetadata.Video != nil:
 		if miw.src.MediaMetadata.Video.Status != "READY" {
-			return []byte{}, errors.Errorf("video %v is not yet processed", miw.src.Filename)
+			return "", errors.Errorf("video %v is not yet processed", miw.src.Filename)
 		}
-		url = fmt.Sprintf("%v=dv", miw.src.BaseUrl)
+		return fmt.Sprintf("%v=dv", miw.src.BaseUrl), nil
 	case miw.src.MediaMetadata.Photo != nil:
-		url = fmt.Sprintf("%v=d", miw.src.BaseUrl)
+		return fmt.Sprintf("%v=d", miw.src.BaseUrl), nil
+	}
+	return "", errors.Errorf("%v is neither a photo nor a video", miw.src.Filename)
+}
+
+func (w *worker) fetchItem(miw *mediaItemWrapper) (io.ReadCloser, error) {
+	url, err := downloadURL(miw)
+	if err != nil {
+		return nil, err
 	}
 
-	if resp, err := w.client.Get(url); err != nil {
-		return []byte{}, errors.Wrapf(err, "error fetching data for %v", miw.src.Filename)
-	} else if resp.StatusCode != 200 {
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		req, err := http.NewRequestWithContext(w.ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "error fetching data for %v", miw.src.Filename)
+		}
+		resp, err := w.client.Do(req)
+		if err != nil {
+			if !errors.Is(err, quota.ErrExhausted) {
+				w.scaler.observe(0, time.Since(start))
+			}
+			return nil, errors.Wrapf(err, "error fetching data for %v", miw.src.Filename)
+		}
+		w.scaler.observe(resp.StatusCode, time.Since(start))
+		if resp.StatusCode == 200 {
+			return resp.Body, nil
+		}
 		body, _ := io.ReadAll(resp.Body)
-		return []byte{}, errors.Errorf("Non 200 status returned for URL %v, body: %v", url, string(body))
+		_ = resp.Body.Close()
+		if !retryable(resp.StatusCode) || attempt >= maxRetries {
+			return nil, errors.Errorf("Non 200 status returned for URL %v, body: %v", url, string(body))
+		}
+		metrics.Retries.Inc()
+		delay := retryAfter(resp.Header, time.Now(), retryDelay<<attempt)
+		w.itemLogger(miw).Debugw("Retrying download", "status", resp.Status, "delay", delay)
+		select {
+		case <-time.After(delay):
+		case <-w.ctx.Done():
+			return nil, errors.Wrapf(w.ctx.Err(), "error fetching data for %v", miw.src.Filename)
+		}
+	}
+}
+
+const (
+	maxRetries = 3
+	// maxRetryAfter caps how long a server's Retry-After can hold a worker.
+	maxRetryAfter = 5 * time.Minute
+)
+
+// retryDelay is doubled on each retry.
+var retryDelay = time.Second
+
+// retryAfter is the delay asked for by the Retry-After header, in seconds or
+// as an HTTP date, or backoff if there is none.
+func retryAfter(h http.Header, now time.Time, backoff time.Duration) time.Duration {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return backoff
+	}
+	var delay time.Duration
+	if secs, err := strconv.Atoi(v); err == nil {
+		delay = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		delay = t.Sub(now)
 	} else {
-		return io.ReadAll(resp.Body)
+		return backoff
 	}
+	return min(max(delay, 0), maxRetryAfter)
 }
 
-func (w *worker) writeItem(miw *mediaItemWrapper, data []byte) error {
-	defer func() {
-		w.logger.Debugf("Worker %v finished %v in %v", w.id, miw.destFilepathShort(), time.Since(miw.startTime))
-	}()
-	if err := os.WriteFile(miw.destFilepath(), data, 0644); err != nil {
-		return errors.Wrapf(err, "writing item %v", miw.src.Id)
+// retryable is true for statuses that may succeed if tried again later.
+func retryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+// countingReader counts the bytes read through it, reporting them to
+// progress if set.
+type countingReader struct {
+	r        io.Reader
+	n        int64
+	progress Progress
+	worker   int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.progress != nil && n > 0 {
+		c.progress.Transferred(c.worker, int64(n))
+	}
+	return n, err
+}
+
+// writeSidecar saves the item's metadata next to it, so its original filename
+// can be restored.
+func (w *worker) writeSidecar(miw *mediaItemWrapper) error {
+	return WriteSidecar(w.store, miw.src, miw.destFilepath())
+}
+
+// WriteSidecar saves mi's metadata next to the item at dest.
+func WriteSidecar(store storage.Storage, mi *photoslibrary.MediaItem, dest string) error {
+	data, err := json.MarshalIndent(mi, "", "\t")
+	if err != nil {
+		return errors.Wrap(err, "marshalling item")
 	}
-	if miw.src.MediaMetadata.CreationTime != "" && !miw.creationTime.IsZero() {
-		return errors.Wrap(os.Chtimes(miw.destFilepath(), miw.creationTime, miw.creationTime), "error changing times")
+	out, err := store.Create(dest+SidecarSuffix, time.Time{})
+	if err != nil {
+		return errors.Wrapf(err, "creating sidecar %v", mi.Id)
+	}
+	if _, err := out.Write(data); err != nil {
+		_ = out.Abort()
+		return errors.Wrapf(err, "writing sidecar %v", mi.Id)
+	}
+	return out.Close()
+}
+
+func (w *worker) writeItem(miw *mediaItemWrapper, body io.Reader) error {
+	var modTime time.Time
+	if miw.src.MediaMetadata.CreationTime != "" {
+		modTime = miw.creationTime
+	}
+	out, err := w.store.Create(miw.destFilepath(), modTime)
+	if err != nil {
+		return errors.Wrapf(err, "creating item %v", miw.src.Id)
+	}
+	if _, err := io.Copy(out, body); err != nil {
+		_ = out.Abort()
+		return errors.Wrapf(err, "writing item %v", miw.src.Id)
 	}
-	return nil
+	return errors.Wrapf(out.Close(), "committing item %v", miw.src.Id)
 }
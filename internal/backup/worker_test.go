@@ -0,0 +1,93 @@
+package backup
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gphotosuploader/googlemirror/api/photoslibrary/v1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/ttomsu/gphotobackup/internal/metrics"
+	"github.com/ttomsu/gphotobackup/internal/storage"
+	"go.uber.org/zap"
+)
+
+func TestProcessRetriesAndCounts(t *testing.T) {
+	retryDelay = time.Millisecond
+	defer func() { retryDelay = time.Second }()
+
+	calls := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		_, _ = w.Write([]byte("photo bytes"))
+	}))
+	defer ts.Close()
+
+	store := storage.NewMemory()
+	w := &worker{ctx: context.Background(), mu: &sync.Mutex{}, client: ts.Client(), store: store, stats: &Stats{}, logger: zap.NewNop().Sugar()}
+	miw := &mediaItemWrapper{
+		src: &photoslibrary.MediaItem{
+			Id:            "one",
+			Filename:      "one.jpg",
+			BaseUrl:       ts.URL + "/one",
+			MediaMetadata: &photoslibrary.MediaMetadata{Photo: &photoslibrary.Photo{}},
+		},
+		destDirName: "2021/05/04",
+		startTime:   time.Now(),
+	}
+
+	retries := testutil.ToFloat64(metrics.Retries)
+	downloaded := testutil.ToFloat64(metrics.ItemsDownloaded)
+	skipped := testutil.ToFloat64(metrics.ItemsSkipped)
+	bytes := testutil.ToFloat64(metrics.BytesDownloaded)
+
+	w.process(miw)
+	if got, err := store.ReadFile(miw.destFilepath()); err != nil || string(got) != "photo bytes" {
+		t.Fatalf("expected item to be written after a retry, got: %q, %v", got, err)
+	}
+	w.process(miw)
+
+	if got := testutil.ToFloat64(metrics.Retries) - retries; got != 1 {
+		t.Errorf("retries = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.ItemsDownloaded) - downloaded; got != 1 {
+		t.Errorf("downloaded = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.ItemsSkipped) - skipped; got != 1 {
+		t.Errorf("skipped = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(metrics.BytesDownloaded) - bytes; got != float64(len("photo bytes")) {
+		t.Errorf("bytes = %v, want %v", got, len("photo bytes"))
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2021, 5, 4, 12, 0, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		header string
+		want   time.Duration
+	}{
+		{"", 2 * time.Second},
+		{"30", 30 * time.Second},
+		{"0", 0},
+		{now.Add(time.Minute).Format(http.TimeFormat), time.Minute},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+		{"86400", maxRetryAfter},
+		{"soon", 2 * time.Second},
+	} {
+		h := http.Header{}
+		if tc.header != "" {
+			h.Set("Retry-After", tc.header)
+		}
+		if got := retryAfter(h, now, 2*time.Second); got != tc.want {
+			t.Errorf("Retry-After %q: delay %v, want %v", tc.header, got, tc.want)
+		}
+	}
+}
@@ -2,7 +2,7 @@ package backup
 
 import (
 	"fmt"
-	"path/filepath"
+	"path"
 	"strings"
 	"time"
 
@@ -10,12 +10,42 @@ import (
 	"github.com/ttomsu/gphotobackup/internal/utils"
 )
 
+// SidecarSuffix is appended to an item's filename for the file holding its
+// metadata, which is written with --sidecars or when filenames are obfuscated.
+const SidecarSuffix = ".json"
+
 type mediaItemWrapper struct {
 	src          *photoslibrary.MediaItem
-	baseDestDir  string
 	creationTime time.Time
 	startTime    time.Time
 	destDirName  string
+	obfuscate    bool
+	sidecar      bool
+}
+
+// Filename returns the name an item is backed up under when filenames are not
+// obfuscated.
+func Filename(mi *photoslibrary.MediaItem) string {
+	return (&mediaItemWrapper{src: mi}).filename(false)
+}
+
+// DestPath returns where an item is backed up in the date tree.
+func DestPath(mi *photoslibrary.MediaItem) string {
+	return destPath(mi, false)
+}
+
+// ObfuscatedDestPath returns where an item is backed up in the date tree with
+// --obfuscate-names.
+func ObfuscatedDestPath(mi *photoslibrary.MediaItem) string {
+	return destPath(mi, true)
+}
+
+func destPath(mi *photoslibrary.MediaItem, obfuscate bool) string {
+	miw := &mediaItemWrapper{src: mi, obfuscate: obfuscate}
+	if mi.MediaMetadata != nil {
+		miw.creationTime, _ = time.Parse(time.RFC3339, mi.MediaMetadata.CreationTime)
+	}
+	return miw.destFilepath()
 }
 
 func (miw *mediaItemWrapper) destDir() string {
@@ -25,18 +55,21 @@ func (miw *mediaItemWrapper) destDir() string {
 	} else if !miw.creationTime.IsZero() {
 		dir = miw.creationTime.Local().Format("2006/01/02")
 	}
-	return filepath.Join(miw.baseDestDir, dir)
+	return dir
 }
 
 func (miw *mediaItemWrapper) destFilepath() string {
-	return filepath.Join(miw.destDir(), miw.filename(false))
+	return path.Join(miw.destDir(), miw.filename(false))
 }
 
 func (miw *mediaItemWrapper) destFilepathShort() string {
-	return filepath.Join(miw.destDir(), miw.filename(true))
+	return path.Join(miw.destDir(), miw.filename(true))
 }
 
 func (miw *mediaItemWrapper) filename(short bool) string {
+	if miw.obfuscate {
+		return utils.Sanitize(miw.src.Id)
+	}
 	lastDotIndex := strings.LastIndex(miw.src.Filename, ".")
 	var filename string
 	if lastDotIndex > 0 {
@@ -36,3 +36,19 @@ func TestFilename(t *testing.T) {
 		})
 	}
 }
+
+func TestFilenameObfuscated(t *testing.T) {
+	miw := &mediaItemWrapper{
+		src: &photoslibrary.MediaItem{
+			Filename: "foobar.jpg",
+			Id:       "id0123-456",
+		},
+		obfuscate: true,
+	}
+	if got, want := miw.filename(false), "id0123_456"; got != want {
+		t.Fatalf("expected: %v, got: %v", want, got)
+	}
+	if got, want := Filename(miw.src), "foobar-id0123-456.jpg"; got != want {
+		t.Fatalf("expected: %v, got: %v", want, got)
+	}
+}
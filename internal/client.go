@@ -4,15 +4,19 @@ import (
 	"context"
 	"encoding/json"
 	"net/http"
+	"strings"
 
 	"github.com/gphotosuploader/googlemirror/api/photoslibrary/v1"
 	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/ttomsu/gphotobackup/internal/quota"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
+	"golang.org/x/time/rate"
 )
 
 func NewClient() (*http.Client, error) {
-	jsonToken, err := ReadFromConfigDir(TokenFilename)
+	jsonToken, store, err := LoadToken()
 	if err != nil {
 		return nil, errors.Wrap(err, "reading token.json. Retry after 'gphotobackup login'")
 	} else if len(jsonToken) == 0 {
@@ -35,5 +39,43 @@ func NewClient() (*http.Client, error) {
 		return nil, errors.Wrap(err, "oauth client cfg")
 	}
 
-	return cfg.Client(context.Background(), token), nil
+	tracker, err := quota.Open(StateDir(), quota.Limits{
+		API:   viper.GetInt64("quota.apiPerDay"),
+		Media: viper.GetInt64("quota.mediaPerDay"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	quotaTracker = tracker
+	var limiter *rate.Limiter
+	if rps := viper.GetFloat64("quota.requestsPerSecond"); rps > 0 {
+		limiter = rate.NewLimiter(rate.Limit(rps), max(1, int(rps)))
+	}
+
+	ctx := context.Background()
+	src := &persistingTokenSource{src: cfg.TokenSource(ctx, token), store: store, last: token}
+	client := oauth2.NewClient(ctx, oauth2.ReuseTokenSource(token, src))
+	client.Transport = &quota.Transport{Base: client.Transport, Tracker: tracker, Limiter: limiter}
+	return client, nil
+}
+
+// quotaTracker counts the requests of the client from NewClient.
+var quotaTracker *quota.Tracker
+
+// Quota returns the usage tracker of the last client from NewClient, or nil.
+func Quota() *quota.Tracker {
+	return quotaTracker
+}
+
+// NewPhotosService returns a Photos Library API client, using the
+// api.endpoint setting in place of Google's endpoint if it's set.
+func NewPhotosService(client *http.Client) (*photoslibrary.Service, error) {
+	svc, err := photoslibrary.New(client)
+	if err != nil {
+		return nil, errors.Wrap(err, "service client")
+	}
+	if endpoint := viper.GetString("api.endpoint"); endpoint != "" {
+		svc.BasePath = strings.TrimSuffix(endpoint, "/") + "/"
+	}
+	return svc, nil
 }
@@ -3,23 +3,98 @@ package internal
 import (
 	"encoding/json"
 	"github.com/pkg/errors"
+	"github.com/ttomsu/gphotobackup/internal/utils"
+	"github.com/zalando/go-keyring"
 	"os"
 	"path/filepath"
+	"sort"
 )
 
+// DefaultProfile keeps its files directly in the config dir, as they were
+// before profiles existed.
+const DefaultProfile = "default"
+
 var (
 	userHome, _           = os.UserHomeDir()
-	gphotobackupConfigDir = filepath.Join(userHome, ".config", "gphotobackup")
+	baseConfigDir         = filepath.Join(userHome, ".config", "gphotobackup")
+	gphotobackupConfigDir = baseConfigDir
+	currentProfile        string
 
 	TokenFilename       = "token.json"
 	OAuthClientFilename = "oauth_client.json"
 )
 
+// SetProfile switches the config dir to that of the named profile.
+func SetProfile(name string) error {
+	if name == "" || name == DefaultProfile {
+		gphotobackupConfigDir = baseConfigDir
+		currentProfile = ""
+		return nil
+	}
+	if utils.Sanitize(name) != name {
+		return errors.Errorf("invalid profile name %q, use only letters, digits and underscores", name)
+	}
+	gphotobackupConfigDir = filepath.Join(baseConfigDir, "profiles", name)
+	currentProfile = name
+	return nil
+}
+
+// Profiles returns the names of the profiles that have logged in. The token
+// may be in the keyring, so this looks for the OAuth client file instead.
+func Profiles() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(baseConfigDir, "profiles"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "reading profiles")
+	}
+	var named []string
+	for _, e := range entries {
+		if _, err := os.Stat(filepath.Join(baseConfigDir, "profiles", e.Name(), OAuthClientFilename)); err == nil {
+			named = append(named, e.Name())
+		}
+	}
+	sort.Strings(named)
+
+	var profiles []string
+	if _, err := os.Stat(filepath.Join(baseConfigDir, OAuthClientFilename)); err == nil {
+		profiles = append(profiles, DefaultProfile)
+	}
+	return append(profiles, named...), nil
+}
+
+// RemoveProfile deletes the named profile's credentials and state.
+func RemoveProfile(name string) error {
+	if keyringAvailable() {
+		if err := keyring.Delete(keyringService, keyringUser(name, TokenFilename)); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+			return errors.Wrap(err, "removing token from keyring")
+		}
+	}
+	if name == "" || name == DefaultProfile {
+		for _, f := range []string{TokenFilename, TokenFilename + ".age", OAuthClientFilename} {
+			if err := os.Remove(filepath.Join(baseConfigDir, f)); err != nil && !os.IsNotExist(err) {
+				return errors.Wrapf(err, "removing %v", f)
+			}
+		}
+		return nil
+	}
+	if utils.Sanitize(name) != name {
+		return errors.Errorf("invalid profile name %q", name)
+	}
+	dir := filepath.Join(baseConfigDir, "profiles", name)
+	if _, err := os.Stat(dir); err != nil {
+		return errors.Wrapf(err, "profile %v", name)
+	}
+	return os.RemoveAll(dir)
+}
+
+// StateDir is where the current profile keeps state between runs.
+func StateDir() string {
+	return gphotobackupConfigDir
+}
+
 func ReadFromConfigDir(filename string) ([]byte, error) {
 	fp := filepath.Join(gphotobackupConfigDir, filename)
 	return os.ReadFile(fp)
 }
-
 func WriteJSONToConfigDir(filename string, j any) error {
 	d, err := json.MarshalIndent(j, "", "\t")
 	if err != nil {
@@ -33,8 +108,21 @@ func WriteToConfigDir(filename string, data []byte) error {
 		return errors.Wrap(err, "creating config dirs")
 	}
 
+	// Write to a temp file first, so a crash can't leave a truncated file.
+	f, err := os.CreateTemp(gphotobackupConfigDir, filename+".*.tmp")
+	if err != nil {
+		return errors.Wrapf(err, "writing config file %v", filename)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return errors.Wrapf(err, "writing config file %v", filename)
+	}
+	if err := f.Close(); err != nil {
+		return errors.Wrapf(err, "writing config file %v", filename)
+	}
 	fp := filepath.Join(gphotobackupConfigDir, filename)
-	if err := os.WriteFile(fp, data, 0600); err != nil {
+	if err := os.Rename(f.Name(), fp); err != nil {
 		return errors.Wrapf(err, "writing config file %v", filename)
 	}
 	return nil
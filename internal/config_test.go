@@ -0,0 +1,47 @@
+package internal
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestProfiles(t *testing.T) {
+	oldBase, oldDir := baseConfigDir, gphotobackupConfigDir
+	defer func() { baseConfigDir, gphotobackupConfigDir = oldBase, oldDir }()
+	baseConfigDir = t.TempDir()
+
+	for _, p := range []string{"default", "bob", "alice"} {
+		if err := SetProfile(p); err != nil {
+			t.Fatal(err)
+		}
+		if err := WriteToConfigDir(OAuthClientFilename, []byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if gphotobackupConfigDir != filepath.Join(baseConfigDir, "profiles", "alice") {
+		t.Errorf("config dir = %v", gphotobackupConfigDir)
+	}
+	if err := SetProfile("../evil"); err == nil {
+		t.Error("expected invalid profile name to fail")
+	}
+
+	got, err := Profiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"default", "alice", "bob"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Profiles() = %v, want %v", got, want)
+	}
+
+	if err := RemoveProfile("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RemoveProfile("bob"); err == nil {
+		t.Error("expected removing a missing profile to fail")
+	}
+	got, _ = Profiles()
+	if want := []string{"default", "alice"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Profiles() = %v, want %v", got, want)
+	}
+}
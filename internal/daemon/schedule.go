@@ -0,0 +1,86 @@
+package daemon
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
+)
+
+// Scheduler decides when the next backup should start: on a cron schedule
+// with some random jitter, or sooner after a failure, backing off
+// exponentially while failures continue.
+type Scheduler struct {
+	schedule   cron.Schedule
+	jitter     time.Duration
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	failures   int
+}
+
+// NewScheduler parses spec, which is a cron expression ("0 3 * * *"), a
+// descriptor such as "@daily", or an interval such as "@every 6h".
+func NewScheduler(spec string, jitter, minBackoff, maxBackoff time.Duration) (*Scheduler, error) {
+	schedule, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid schedule %q", spec)
+	}
+	if maxBackoff < minBackoff {
+		maxBackoff = minBackoff
+	}
+	return &Scheduler{
+		schedule:   schedule,
+		jitter:     jitter,
+		minBackoff: minBackoff,
+		maxBackoff: maxBackoff,
+	}, nil
+}
+
+// Next returns the start time of the next run after now.
+func (s *Scheduler) Next(now time.Time) time.Time {
+	next := s.schedule.Next(now)
+	if s.jitter > 0 {
+		next = next.Add(time.Duration(rand.Int63n(int64(s.jitter))))
+	}
+	if s.failures > 0 {
+		if retry := now.Add(s.Backoff()); retry.Before(next) {
+			return retry
+		}
+	}
+	return next
+}
+
+// Backoff is the delay before retrying after the current run of failures.
+func (s *Scheduler) Backoff() time.Duration {
+	if s.failures == 0 {
+		return 0
+	}
+	d := s.minBackoff
+	for i := 1; i < s.failures && d < s.maxBackoff; i++ {
+		d *= 2
+	}
+	return min(d, s.maxBackoff)
+}
+
+// Done records the outcome of a run.
+func (s *Scheduler) Done(err error) {
+	if err != nil {
+		s.failures++
+	} else {
+		s.failures = 0
+	}
+}
+
+// Failures is the number of consecutive failed runs.
+func (s *Scheduler) Failures() int {
+	return s.failures
+}
+
+// Reload takes the schedule, jitter and backoff limits from next, keeping the
+// count of consecutive failures so the backoff carries on where it was.
+func (s *Scheduler) Reload(next *Scheduler) {
+	failures := s.failures
+	*s = *next
+	s.failures = failures
+}
@@ -0,0 +1,49 @@
+package daemon
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	s, err := NewScheduler("0 3 * * *", 10*time.Minute, time.Minute, 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	three := time.Date(2024, 5, 2, 3, 0, 0, 0, time.Local)
+	for i := 0; i < 20; i++ {
+		if next := s.Next(now); next.Before(three) || !next.Before(three.Add(10*time.Minute)) {
+			t.Fatalf("Next = %v, want within jitter of %v", next, three)
+		}
+	}
+
+	fail := errors.New("fail")
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute, 10 * time.Minute, 10 * time.Minute} {
+		s.Done(fail)
+		if got := s.Next(now).Sub(now); got != want {
+			t.Errorf("after %v failures, next run in %v, want %v", s.Failures(), got, want)
+		}
+	}
+
+	reloaded, err := NewScheduler("0 4 * * *", 0, time.Minute, 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Reload(reloaded)
+	if got := s.Next(now).Sub(now); s.Failures() != 6 || got != 10*time.Minute {
+		t.Errorf("after reloading, %v failures and next run in %v, want the backoff kept", s.Failures(), got)
+	}
+	s.Done(nil)
+	if next := s.Next(now); next.Before(three) {
+		t.Errorf("expected success to reset the backoff, next run at %v", next)
+	}
+
+	if _, err := NewScheduler("every day", 0, time.Minute, time.Hour); err == nil {
+		t.Error("expected invalid schedule to fail")
+	}
+	if _, err := NewScheduler("@every 6h", 0, time.Minute, time.Hour); err != nil {
+		t.Errorf("interval schedule: %v", err)
+	}
+}
@@ -0,0 +1,386 @@
+// Package fakephotos is an in-memory stand-in for the Google Photos Library
+// API and its media downloads, for tests that run a backup end to end
+// without network access.
+package fakephotos
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gphotosuploader/googlemirror/api/photoslibrary/v1"
+	"github.com/pkg/errors"
+)
+
+// Item is a media item held by the server.
+type Item struct {
+	ID       string
+	Filename string
+	Created  time.Time
+	Data     []byte
+	// Video makes the item a video, downloaded with =dv. Status is its
+	// processing status, READY if empty.
+	Video    bool
+	Status   string
+	Favorite bool
+}
+
+type album struct {
+	id, title string
+	shared    bool
+	items     []string
+}
+
+// Server serves the Photos Library API under /v1/ and media downloads under
+// /media/. Point a client at it with Endpoint.
+type Server struct {
+	*httptest.Server
+
+	// PageSize caps the page size of searches and listings, to exercise
+	// pagination. Zero means the size the client asks for.
+	PageSize int
+
+	mu         sync.Mutex
+	items      []*Item
+	byID       map[string]*Item
+	albums     []*album
+	generation int
+	apiFails   int
+	mediaFails map[string]int
+	requests   map[string]int
+}
+
+// New starts a server with no items. Close it when done.
+func New() *Server {
+	s := &Server{byID: map[string]*Item{}, mediaFails: map[string]int{}, requests: map[string]int{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/mediaItems:search", s.api("search", s.search))
+	mux.HandleFunc("GET /v1/mediaItems:batchGet", s.api("batchGet", s.batchGet))
+	mux.HandleFunc("GET /v1/mediaItems/{id}", s.api("get", s.getItem))
+	mux.HandleFunc("GET /v1/albums", s.api("albums", s.listAlbums(false)))
+	mux.HandleFunc("GET /v1/sharedAlbums", s.api("sharedAlbums", s.listAlbums(true)))
+	mux.HandleFunc("GET /media/{id}/{download}", s.download)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Endpoint is the API base path for the client, e.g. the api.endpoint
+// setting.
+func (s *Server) Endpoint() string {
+	return s.URL + "/"
+}
+
+// Add adds items, in the order searches return them.
+func (s *Server) Add(items ...Item) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, it := range items {
+		s.items = append(s.items, &it)
+		s.byID[it.ID] = &it
+	}
+}
+
+// AddAlbum adds an album holding the items with the given IDs. Shared albums
+// are listed by sharedAlbums rather than albums.
+func (s *Server) AddAlbum(id, title string, shared bool, itemIDs ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.albums = append(s.albums, &album{id: id, title: title, shared: shared, items: itemIDs})
+}
+
+// SetStatus changes the processing status of a video.
+func (s *Server) SetStatus(id, status string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byID[id].Status = status
+}
+
+// FailAPI makes the next n API calls fail with 429 Too Many Requests.
+func (s *Server) FailAPI(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apiFails = n
+}
+
+// FailDownloads makes the next n downloads of the item fail with 429 Too
+// Many Requests.
+func (s *Server) FailDownloads(id string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mediaFails[id] = n
+}
+
+// ExpireURLs makes every baseUrl handed out so far return 403 Forbidden, as
+// Google's do about an hour after they're issued.
+func (s *Server) ExpireURLs() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.generation++
+}
+
+// Requests is the number of requests of a kind served so far, counting
+// failures: search, batchGet, get, albums, sharedAlbums or download.
+func (s *Server) Requests(kind string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[kind]
+}
+
+// api wraps an API handler with the scripted 429s and JSON encoding.
+func (s *Server) api(kind string, h func(r *http.Request) (any, int)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests[kind]++
+		fail := s.apiFails > 0
+		if fail {
+			s.apiFails--
+		}
+		s.mu.Unlock()
+		if fail {
+			writeError(w, http.StatusTooManyRequests, "RESOURCE_EXHAUSTED", "Quota exceeded")
+			return
+		}
+		resp, status := h(r)
+		if status != http.StatusOK {
+			writeError(w, status, http.StatusText(status), fmt.Sprint(resp))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, status, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{"code": code, "message": message, "status": status},
+	})
+}
+
+func (s *Server) search(r *http.Request) (any, int) {
+	req := &photoslibrary.SearchMediaItemsRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		return err, http.StatusBadRequest
+	}
+	if req.AlbumId != "" && req.Filters != nil {
+		return "albumId and filters can't be used together", http.StatusBadRequest
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var matches []*Item
+	if req.AlbumId != "" {
+		a := s.album(req.AlbumId)
+		if a == nil {
+			return "album not found", http.StatusNotFound
+		}
+		for _, id := range a.items {
+			matches = append(matches, s.byID[id])
+		}
+	} else {
+		for _, it := range s.items {
+			if matchesFilters(it, req.Filters) {
+				matches = append(matches, it)
+			}
+		}
+	}
+
+	page, next, err := s.page(len(matches), int(req.PageSize), req.PageToken)
+	if err != nil {
+		return err, http.StatusBadRequest
+	}
+	resp := &photoslibrary.SearchMediaItemsResponse{NextPageToken: next}
+	for _, it := range matches[page.start:page.end] {
+		resp.MediaItems = append(resp.MediaItems, s.mediaItem(it))
+	}
+	return resp, http.StatusOK
+}
+
+func (s *Server) getItem(r *http.Request) (any, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	it, ok := s.byID[r.PathValue("id")]
+	if !ok {
+		return "media item not found", http.StatusNotFound
+	}
+	return s.mediaItem(it), http.StatusOK
+}
+
+type mediaItemResult struct {
+	MediaItem *photoslibrary.MediaItem `json:"mediaItem,omitempty"`
+	Status    *status                  `json:"status,omitempty"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (s *Server) batchGet(r *http.Request) (any, int) {
+	ids := r.URL.Query()["mediaItemIds"]
+	if len(ids) == 0 || len(ids) > 50 {
+		return "mediaItemIds must have between 1 and 50 IDs", http.StatusBadRequest
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	results := []mediaItemResult{}
+	for _, id := range ids {
+		if it, ok := s.byID[id]; ok {
+			results = append(results, mediaItemResult{MediaItem: s.mediaItem(it)})
+		} else {
+			// 5 is NOT_FOUND in google.rpc.Code.
+			results = append(results, mediaItemResult{Status: &status{Code: 5, Message: "media item not found"}})
+		}
+	}
+	return map[string]any{"mediaItemResults": results}, http.StatusOK
+}
+
+func (s *Server) listAlbums(shared bool) func(r *http.Request) (any, int) {
+	return func(r *http.Request) (any, int) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		var albums []*album
+		for _, a := range s.albums {
+			if a.shared == shared {
+				albums = append(albums, a)
+			}
+		}
+		pageSize, _ := strconv.Atoi(r.URL.Query().Get("pageSize"))
+		page, next, err := s.page(len(albums), pageSize, r.URL.Query().Get("pageToken"))
+		if err != nil {
+			return err, http.StatusBadRequest
+		}
+		var out []*photoslibrary.Album
+		for _, a := range albums[page.start:page.end] {
+			out = append(out, &photoslibrary.Album{Id: a.id, Title: a.title, TotalMediaItems: int64(len(a.items))})
+		}
+		if shared {
+			return &photoslibrary.ListSharedAlbumsResponse{SharedAlbums: out, NextPageToken: next}, http.StatusOK
+		}
+		return &photoslibrary.ListAlbumsResponse{Albums: out, NextPageToken: next}, http.StatusOK
+	}
+}
+
+func (s *Server) download(w http.ResponseWriter, r *http.Request) {
+	generation, suffix, ok := strings.Cut(r.PathValue("download"), "=")
+	s.mu.Lock()
+	s.requests["download"]++
+	it, found := s.byID[r.PathValue("id")]
+	expired := generation != strconv.Itoa(s.generation)
+	fail := s.mediaFails[r.PathValue("id")] > 0
+	if fail {
+		s.mediaFails[r.PathValue("id")]--
+	}
+	s.mu.Unlock()
+
+	switch {
+	case !ok || !found:
+		http.NotFound(w, r)
+	case expired:
+		http.Error(w, "URL expired", http.StatusForbidden)
+	case fail:
+		http.Error(w, "Too many requests", http.StatusTooManyRequests)
+	case it.Video && suffix != "dv", !it.Video && suffix != "d":
+		http.Error(w, "unsupported download parameter "+suffix, http.StatusBadRequest)
+	case it.Video && it.Status != "" && it.Status != "READY":
+		http.Error(w, "video is still processing", http.StatusNotFound)
+	default:
+		w.Header().Set("Content-Length", strconv.Itoa(len(it.Data)))
+		if r.Method != http.MethodHead {
+			_, _ = w.Write(it.Data)
+		}
+	}
+}
+
+func (s *Server) album(id string) *album {
+	for _, a := range s.albums {
+		if a.id == id {
+			return a
+		}
+	}
+	return nil
+}
+
+// mediaItem returns the API's view of it, with a baseUrl from the current
+// generation.
+func (s *Server) mediaItem(it *Item) *photoslibrary.MediaItem {
+	mi := &photoslibrary.MediaItem{
+		Id:       it.ID,
+		Filename: it.Filename,
+		BaseUrl:  fmt.Sprintf("%v/media/%v/%v", s.URL, it.ID, s.generation),
+		MediaMetadata: &photoslibrary.MediaMetadata{
+			CreationTime: it.Created.UTC().Format(time.RFC3339),
+		},
+	}
+	if it.Video {
+		mi.MimeType = "video/mp4"
+		status := it.Status
+		if status == "" {
+			status = "READY"
+		}
+		mi.MediaMetadata.Video = &photoslibrary.Video{Status: status}
+	} else {
+		mi.MimeType = "image/jpeg"
+		mi.MediaMetadata.Photo = &photoslibrary.Photo{}
+	}
+	return mi
+}
+
+type pageRange struct{ start, end int }
+
+// page picks the slice of n results for a page token, which is the offset
+// of the page's first result.
+func (s *Server) page(n, pageSize int, token string) (pageRange, string, error) {
+	if pageSize <= 0 {
+		pageSize = 25
+	}
+	if s.PageSize > 0 && pageSize > s.PageSize {
+		pageSize = s.PageSize
+	}
+	start := 0
+	if token != "" {
+		var err error
+		if start, err = strconv.Atoi(token); err != nil || start < 0 || start > n {
+			return pageRange{}, "", errors.Errorf("invalid page token %q", token)
+		}
+	}
+	end := min(start+pageSize, n)
+	next := ""
+	if end < n {
+		next = strconv.Itoa(end)
+	}
+	return pageRange{start, end}, next, nil
+}
+
+func matchesFilters(it *Item, f *photoslibrary.Filters) bool {
+	if f == nil {
+		return true
+	}
+	if f.FeatureFilter != nil {
+		for _, feature := range f.FeatureFilter.IncludedFeatures {
+			if feature == "FAVORITES" && !it.Favorite {
+				return false
+			}
+		}
+	}
+	if f.DateFilter != nil && len(f.DateFilter.Ranges) > 0 {
+		day := it.Created.UTC().Format("2006-01-02")
+		for _, r := range f.DateFilter.Ranges {
+			if formatDate(r.StartDate) <= day && day <= formatDate(r.EndDate) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func formatDate(d *photoslibrary.Date) string {
+	return fmt.Sprintf("%04d-%02d-%02d", d.Year, d.Month, d.Day)
+}
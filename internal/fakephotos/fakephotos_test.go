@@ -0,0 +1,56 @@
+package fakephotos
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gphotosuploader/googlemirror/api/photoslibrary/v1"
+)
+
+func TestAlbumsAndBatchGet(t *testing.T) {
+	s := New()
+	defer s.Close()
+	s.PageSize = 1
+	s.Add(Item{ID: "a", Filename: "a.jpg", Created: time.Now()}, Item{ID: "b", Filename: "b.jpg", Created: time.Now()})
+	s.AddAlbum("mine", "Mine", false, "a")
+	s.AddAlbum("shared1", "Shared 1", true, "a", "b")
+	s.AddAlbum("shared2", "Shared 2", true, "b")
+
+	svc, err := photoslibrary.New(s.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.BasePath = s.Endpoint()
+
+	var shared []string
+	err = svc.SharedAlbums.List().Pages(context.Background(), func(resp *photoslibrary.ListSharedAlbumsResponse) error {
+		for _, a := range resp.SharedAlbums {
+			shared = append(shared, a.Id)
+		}
+		return nil
+	})
+	if err != nil || len(shared) != 2 || shared[0] != "shared1" || shared[1] != "shared2" {
+		t.Errorf("shared albums = %v, %v", shared, err)
+	}
+	if got := s.Requests("sharedAlbums"); got != 2 {
+		t.Errorf("sharedAlbums requests = %v, want 2 pages", got)
+	}
+
+	resp, err := s.Client().Get(s.URL + "/v1/mediaItems:batchGet?mediaItemIds=b&mediaItemIds=missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var batch struct {
+		MediaItemResults []mediaItemResult `json:"mediaItemResults"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		t.Fatal(err)
+	}
+	results := batch.MediaItemResults
+	if len(results) != 2 || results[0].MediaItem == nil || results[0].MediaItem.Id != "b" || results[1].Status == nil {
+		t.Errorf("batchGet = %+v", results)
+	}
+}
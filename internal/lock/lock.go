@@ -0,0 +1,100 @@
+package lock
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gofrs/flock"
+	"github.com/pkg/errors"
+)
+
+// Owner identifies the process holding a lock. It is written into the lock
+// file so others can report who they're waiting on.
+type Owner struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	Started time.Time `json:"started"`
+}
+
+func (o Owner) String() string {
+	return fmt.Sprintf("pid %v on %v, started %v", o.PID, o.Host, o.Started.Local().Format(time.RFC1123))
+}
+
+// LockedError is returned when another process holds the lock.
+type LockedError struct {
+	Path  string
+	Owner *Owner
+}
+
+func (e *LockedError) Error() string {
+	if e.Owner == nil {
+		return fmt.Sprintf("%v is locked by another backup", e.Path)
+	}
+	return fmt.Sprintf("%v is locked by another backup (%v)", e.Path, e.Owner)
+}
+
+// Lock is an advisory flock on a file.
+type Lock struct {
+	fl *flock.Flock
+	// Stale is the previous owner when it exited without releasing the lock.
+	Stale *Owner
+}
+
+// Acquire takes the lock at path, creating it if needed. If another process
+// holds it, Acquire polls every pollInterval until wait has passed, and then
+// returns a *LockedError. A wait of 0 fails at once.
+func Acquire(path string, wait, pollInterval time.Duration) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, errors.Wrap(err, "creating lock dir")
+	}
+	fl := flock.New(path)
+	deadline := time.Now().Add(wait)
+	for {
+		ok, err := fl.TryLock()
+		if err != nil {
+			return nil, errors.Wrapf(err, "locking %v", path)
+		}
+		if ok {
+			break
+		}
+		if !time.Now().Before(deadline) {
+			return nil, &LockedError{Path: path, Owner: readOwner(path)}
+		}
+		time.Sleep(min(pollInterval, time.Until(deadline)))
+	}
+
+	// Whoever wrote the file last clears it on Release, so an owner left in
+	// an unlocked file means that process died mid-run.
+	l := &Lock{fl: fl, Stale: readOwner(path)}
+	host, _ := os.Hostname()
+	owner, _ := json.Marshal(Owner{PID: os.Getpid(), Host: host, Started: time.Now()})
+	if err := os.WriteFile(path, owner, 0644); err != nil {
+		_ = fl.Unlock()
+		return nil, errors.Wrapf(err, "writing lock %v", path)
+	}
+	return l, nil
+}
+
+// Release clears the owner and unlocks.
+func (l *Lock) Release() error {
+	if err := os.Truncate(l.fl.Path(), 0); err != nil {
+		_ = l.fl.Unlock()
+		return errors.Wrap(err, "clearing lock")
+	}
+	return l.fl.Unlock()
+}
+
+func readOwner(path string) *Owner {
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) == 0 {
+		return nil
+	}
+	o := &Owner{}
+	if err := json.Unmarshal(data, o); err != nil {
+		return nil
+	}
+	return o
+}
@@ -0,0 +1,55 @@
+package lock
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out", ".gphotobackup.lock")
+
+	l, err := Acquire(path, 0, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.Stale != nil {
+		t.Errorf("unexpected stale owner %v", l.Stale)
+	}
+
+	_, err = Acquire(path, 20*time.Millisecond, 5*time.Millisecond)
+	var locked *LockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("expected LockedError, got %v", err)
+	}
+	if locked.Owner == nil || locked.Owner.PID != os.Getpid() {
+		t.Errorf("expected owner to be this process, got %v", locked.Owner)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_ = l.Release()
+	}()
+	l2, err := Acquire(path, 5*time.Second, 5*time.Millisecond)
+	if err != nil {
+		t.Fatalf("expected to get the lock after waiting: %v", err)
+	}
+	if l2.Stale != nil {
+		t.Errorf("a released lock isn't stale, got %v", l2.Stale)
+	}
+
+	// Simulate a crash: the owner is left behind but the flock is gone.
+	if err := l2.fl.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+	l3, err := Acquire(path, 0, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l3.Stale == nil || l3.Stale.PID != os.Getpid() {
+		t.Errorf("expected stale owner to be reported, got %v", l3.Stale)
+	}
+	_ = l3.Release()
+}
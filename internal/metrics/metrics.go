@@ -0,0 +1,93 @@
+package metrics
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gphotobackup"
+
+// Registry holds only this tool's metrics, so the textfile doesn't repeat
+// the Go runtime metrics node_exporter already reports about itself.
+var Registry = prometheus.NewRegistry()
+
+var (
+	ItemsDownloaded = newCounter("items_downloaded_total", "Media items downloaded and written to the destination.")
+	ItemsSkipped    = newCounter("items_skipped_total", "Media items skipped because they were already backed up.")
+	ItemsFailed     = newCounter("items_failed_total", "Media items that could not be downloaded or written.")
+	BytesDownloaded = newCounter("bytes_downloaded_total", "Bytes of media downloaded.")
+	APICalls        = newCounter("api_calls_total", "Photos Library API requests, one per page of results.")
+	Retries         = newCounter("retries_total", "Downloads retried after a transient error.")
+
+	DownloadDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "download_duration_seconds",
+		Help:      "Time to download and write one media item.",
+		Buckets:   prometheus.ExponentialBuckets(0.25, 2, 10),
+	})
+
+	QueueDepth = newGauge("queue_depth", "Media items found but not yet picked up by a worker.")
+	Workers    = newGauge("workers", "Download workers running.")
+	// LastSuccess is the Unix time the last backup finished without errors.
+	LastSuccess = newGauge("last_success_timestamp_seconds", "Unix time of the last backup that finished without errors.")
+)
+
+func init() {
+	Registry.MustRegister(DownloadDuration)
+}
+
+func newCounter(name, help string) prometheus.Counter {
+	c := prometheus.NewCounter(prometheus.CounterOpts{Namespace: namespace, Name: name, Help: help})
+	Registry.MustRegister(c)
+	return c
+}
+
+func newGauge(name, help string) prometheus.Gauge {
+	g := prometheus.NewGauge(prometheus.GaugeOpts{Namespace: namespace, Name: name, Help: help})
+	Registry.MustRegister(g)
+	return g
+}
+
+// Handler serves the metrics for scraping.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// WriteTextfile writes the metrics for node_exporter's textfile collector.
+// The file is replaced atomically, as the collector requires.
+func WriteTextfile(path string) error {
+	return errors.Wrapf(prometheus.WriteToTextfile(path, Registry), "writing metrics to %v", path)
+}
+
+// lastSuccessFile keeps LastSuccess across one-shot runs.
+const lastSuccessFile = "last_success"
+
+// LoadLastSuccess sets LastSuccess from the time saved in stateDir.
+func LoadLastSuccess(stateDir string) {
+	data, err := os.ReadFile(filepath.Join(stateDir, lastSuccessFile))
+	if err != nil {
+		return
+	}
+	if ts, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64); err == nil {
+		LastSuccess.Set(float64(ts))
+	}
+}
+
+// RecordSuccess sets LastSuccess to now and saves it in stateDir.
+func RecordSuccess(stateDir string) error {
+	now := time.Now().Unix()
+	LastSuccess.Set(float64(now))
+	if err := os.MkdirAll(stateDir, 0700); err != nil {
+		return errors.Wrap(err, "creating state dir")
+	}
+	return errors.Wrap(os.WriteFile(filepath.Join(stateDir, lastSuccessFile), []byte(strconv.FormatInt(now, 10)), 0600),
+		"saving last success time")
+}
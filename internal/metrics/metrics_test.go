@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestTextfileAndLastSuccess(t *testing.T) {
+	dir := t.TempDir()
+	if err := RecordSuccess(dir); err != nil {
+		t.Fatal(err)
+	}
+	saved := testutil.ToFloat64(LastSuccess)
+	LastSuccess.Set(0)
+	LoadLastSuccess(dir)
+	if got := testutil.ToFloat64(LastSuccess); got != saved || got == 0 {
+		t.Errorf("LastSuccess = %v, want %v", got, saved)
+	}
+
+	ItemsDownloaded.Inc()
+	path := filepath.Join(dir, "gphotobackup.prom")
+	if err := WriteTextfile(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"gphotobackup_items_downloaded_total 1", "gphotobackup_last_success_timestamp_seconds", "gphotobackup_download_duration_seconds_bucket"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("textfile missing %q:\n%s", want, data)
+		}
+	}
+}
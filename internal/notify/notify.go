@@ -0,0 +1,203 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/ttomsu/gphotobackup/internal/utils"
+)
+
+const (
+	defaultTitle   = `gphotobackup {{if .Success}}finished{{else if .Deferred}}paused{{else if .Stopped}}stopped{{else}}FAILED{{end}} on {{.Host}}`
+	defaultMessage = `Downloaded {{.Downloaded}} items ({{bytes .Bytes}}), skipped {{.Skipped}}, failed {{.Failed}} in {{.Duration}}.{{if .Deferred}}
+Stopped by the daily API quota, the rest will be backed up after it resets.{{else if .Stopped}}
+Stopped before finishing, the rest will be backed up by the next run.{{end}}{{if .Error}}
+Error: {{.Error}}{{end}}`
+)
+
+// Summary describes a finished backup run.
+type Summary struct {
+	Profile    string        `json:"profile"`
+	Host       string        `json:"host"`
+	Start      time.Time     `json:"start"`
+	Duration   time.Duration `json:"-"`
+	Downloaded int64         `json:"downloaded"`
+	Skipped    int64         `json:"skipped"`
+	Failed     int64         `json:"failed"`
+	Bytes      int64         `json:"bytes"`
+	Error      string        `json:"error,omitempty"`
+	Success    bool          `json:"success"`
+	// Deferred runs stopped when the daily quota ran out. They're neither
+	// failed nor successful, and are picked up by the next run.
+	Deferred bool `json:"deferred,omitempty"`
+	// Stopped runs were interrupted, e.g. by the daemon shutting down. Like
+	// deferred runs, they're neither failed nor successful.
+	Stopped bool `json:"stopped,omitempty"`
+}
+
+// failed is true for runs that neither succeeded nor were deferred or
+// stopped.
+func (s *Summary) failed() bool {
+	return !s.Success && !s.Deferred && !s.Stopped
+}
+
+// Message is what a sink sends: the rendered title and body, and the
+// summary they were rendered from.
+type Message struct {
+	Title   string
+	Body    string
+	Summary *Summary
+}
+
+// Sink delivers messages to one destination.
+type Sink interface {
+	Send(ctx context.Context, m *Message) error
+}
+
+// SinkConfig is one entry of notify.sinks in the config. Type selects the
+// sink; the other fields apply to the sinks that use them.
+type SinkConfig struct {
+	Type string
+	// On is "failure" (the default) or "always".
+	On string
+
+	URL   string
+	Token string
+
+	Host     string
+	Port     int
+	User     string
+	Password string
+	From     string
+	To       []string
+}
+
+// Config is the notify section of the config.
+type Config struct {
+	// On is the default for sinks that don't set their own.
+	On      string
+	Title   string
+	Message string
+	Sinks   []SinkConfig
+}
+
+type target struct {
+	sink   Sink
+	always bool
+	name   string
+}
+
+// Notifier sends run summaries to the configured sinks.
+type Notifier struct {
+	title   *template.Template
+	message *template.Template
+	targets []target
+}
+
+// FromConfig builds a Notifier from the notify section of the config. It
+// returns nil if no sinks are configured.
+func FromConfig() (*Notifier, error) {
+	cfg := Config{}
+	if err := viper.UnmarshalKey("notify", &cfg); err != nil {
+		return nil, errors.Wrap(err, "reading notify config")
+	}
+	if len(cfg.Sinks) == 0 {
+		return nil, nil
+	}
+	return New(cfg)
+}
+
+// New builds a Notifier from cfg.
+func New(cfg Config) (*Notifier, error) {
+	n := &Notifier{}
+	var err error
+	if n.title, err = parseTemplate("title", cfg.Title, defaultTitle); err != nil {
+		return nil, err
+	}
+	if n.message, err = parseTemplate("message", cfg.Message, defaultMessage); err != nil {
+		return nil, err
+	}
+
+	for i, sc := range cfg.Sinks {
+		var sink Sink
+		switch strings.ToLower(sc.Type) {
+		case "webhook":
+			sink, err = newWebhook(sc)
+		case "ntfy":
+			sink, err = newNtfy(sc)
+		case "gotify":
+			sink, err = newGotify(sc)
+		case "smtp", "email":
+			sink, err = newSMTP(sc)
+		default:
+			err = errors.Errorf("unknown type %q", sc.Type)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "notify sink %v", i)
+		}
+
+		on := sc.On
+		if on == "" {
+			on = cfg.On
+		}
+		switch on {
+		case "", "failure", "always":
+		default:
+			return nil, errors.Errorf("notify sink %v: 'on' must be failure or always, not %q", i, on)
+		}
+		n.targets = append(n.targets, target{sink: sink, always: on == "always", name: sc.Type})
+	}
+	return n, nil
+}
+
+func parseTemplate(name, text, def string) (*template.Template, error) {
+	if text == "" {
+		text = def
+	}
+	t, err := template.New(name).Funcs(template.FuncMap{"bytes": utils.FormatBytes}).Parse(text)
+	return t, errors.Wrapf(err, "notify %v template", name)
+}
+
+// Notify sends s to every sink that wants it, returning the errors of those
+// that failed.
+func (n *Notifier) Notify(ctx context.Context, s *Summary) error {
+	if s.Host == "" {
+		s.Host, _ = os.Hostname()
+	}
+	m := &Message{Summary: s}
+	var err error
+	if m.Title, err = render(n.title, s); err != nil {
+		return err
+	}
+	if m.Body, err = render(n.message, s); err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, t := range n.targets {
+		if !s.failed() && !t.always {
+			continue
+		}
+		if serr := t.sink.Send(ctx, m); serr != nil {
+			failed = append(failed, t.name+": "+serr.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return errors.Errorf("sending notifications: %v", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+func render(t *template.Template, s *Summary) (string, error) {
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, s); err != nil {
+		return "", errors.Wrapf(err, "rendering notify %v template", t.Name())
+	}
+	return buf.String(), nil
+}
@@ -0,0 +1,175 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeSMTP accepts one email and sends its DATA on the returned channel.
+func fakeSMTP(t *testing.T) (string, int, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	mail := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { _, _ = io.WriteString(conn, s+"\r\n") }
+		reply("220 localhost ready")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "DATA":
+				reply("354 go ahead")
+				data := &strings.Builder{}
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil || l == ".\r\n" {
+						break
+					}
+					data.WriteString(l)
+				}
+				mail <- data.String()
+				reply("250 queued")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	addr := l.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, mail
+}
+
+func TestNotify(t *testing.T) {
+	requests := make(chan *http.Request, 10)
+	bodies := make(chan string, 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- r
+		bodies <- string(body)
+	}))
+	defer ts.Close()
+	host, port, mail := fakeSMTP(t)
+
+	n, err := New(Config{
+		Title: "{{.Profile}}: {{if .Success}}ok{{else}}failed{{end}}",
+		Sinks: []SinkConfig{
+			{Type: "webhook", URL: ts.URL + "/hook", On: "always"},
+			{Type: "ntfy", URL: ts.URL + "/topic", Token: "tk"},
+			{Type: "gotify", URL: ts.URL, Token: "app"},
+			{Type: "smtp", Host: host, Port: port, From: "nas@example.com", To: []string{"me@example.com"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary := &Summary{Profile: "alice", Downloaded: 3, Bytes: 2048, Duration: time.Minute, Success: true}
+	if err := n.Notify(context.Background(), summary); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-requests; r.URL.Path != "/hook" {
+		t.Fatalf("expected only the webhook on success, got %v", r.URL.Path)
+	}
+	hook := map[string]any{}
+	if err := json.Unmarshal([]byte(<-bodies), &hook); err != nil {
+		t.Fatal(err)
+	}
+	if hook["title"] != "alice: ok" || hook["downloaded"] != float64(3) || hook["durationSeconds"] != float64(60) {
+		t.Errorf("unexpected webhook payload: %v", hook)
+	}
+	if len(requests) != 0 {
+		t.Fatalf("failure-only sinks were sent a success")
+	}
+
+	summary = &Summary{Profile: "alice", Downloaded: 1, Deferred: true}
+	if err := n.Notify(context.Background(), summary); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-requests; r.URL.Path != "/hook" {
+		t.Fatalf("expected only the webhook for a run stopped by the quota, got %v", r.URL.Path)
+	}
+	if body := <-bodies; !strings.Contains(body, "daily API quota") || !strings.Contains(body, `"deferred":true`) {
+		t.Errorf("unexpected webhook payload for a deferred run: %v", body)
+	}
+	if len(requests) != 0 {
+		t.Fatalf("failure-only sinks were sent a deferred run")
+	}
+
+	summary = &Summary{Profile: "alice", Downloaded: 1, Stopped: true}
+	if err := n.Notify(context.Background(), summary); err != nil {
+		t.Fatal(err)
+	}
+	if r := <-requests; r.URL.Path != "/hook" {
+		t.Fatalf("expected only the webhook for a stopped run, got %v", r.URL.Path)
+	}
+	if body := <-bodies; !strings.Contains(body, "next run") || !strings.Contains(body, `"stopped":true`) {
+		t.Errorf("unexpected webhook payload for a stopped run: %v", body)
+	}
+	if len(requests) != 0 {
+		t.Fatalf("failure-only sinks were sent a stopped run")
+	}
+
+	summary = &Summary{Profile: "alice", Failed: 1, Error: "boom"}
+	if err := n.Notify(context.Background(), summary); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]*http.Request{}
+	for i := 0; i < 3; i++ {
+		r := <-requests
+		got[r.URL.Path] = r
+		body := <-bodies
+		if r.URL.Path == "/topic" && !strings.Contains(body, "Error: boom") {
+			t.Errorf("ntfy body missing error: %q", body)
+		}
+	}
+	if r := got["/topic"]; r == nil || r.Header.Get("Title") != "alice: failed" || r.Header.Get("Authorization") != "Bearer tk" {
+		t.Errorf("unexpected ntfy request: %v", r)
+	}
+	if r := got["/message"]; r == nil || r.Header.Get("X-Gotify-Key") != "app" {
+		t.Errorf("unexpected gotify request: %v", r)
+	}
+	select {
+	case m := <-mail:
+		if !strings.Contains(m, "Subject: alice: failed") || !strings.Contains(m, "failed 1") {
+			t.Errorf("unexpected email:\n%v", m)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no email sent")
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	for _, cfg := range []Config{
+		{Sinks: []SinkConfig{{Type: "pager"}}},
+		{Sinks: []SinkConfig{{Type: "webhook"}}},
+		{Sinks: []SinkConfig{{Type: "webhook", URL: "http://x", On: "sometimes"}}},
+		{Title: "{{.Nope", Sinks: []SinkConfig{{Type: "webhook", URL: "http://x"}}},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// webhook POSTs the summary as JSON.
+type webhook struct {
+	url string
+}
+
+func newWebhook(sc SinkConfig) (Sink, error) {
+	if sc.URL == "" {
+		return nil, errors.New("webhook needs a url")
+	}
+	return &webhook{url: sc.URL}, nil
+}
+
+func (w *webhook) Send(ctx context.Context, m *Message) error {
+	payload := struct {
+		*Summary
+		DurationSeconds float64 `json:"durationSeconds"`
+		Title           string  `json:"title"`
+		Message         string  `json:"message"`
+	}{m.Summary, m.Summary.Duration.Seconds(), m.Title, m.Body}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return post(ctx, w.url, "application/json", data, nil)
+}
+
+// ntfy publishes the message to an ntfy topic URL.
+type ntfy struct {
+	url   string
+	token string
+}
+
+func newNtfy(sc SinkConfig) (Sink, error) {
+	if sc.URL == "" {
+		return nil, errors.New("ntfy needs the topic url")
+	}
+	return &ntfy{url: sc.URL, token: sc.Token}, nil
+}
+
+func (n *ntfy) Send(ctx context.Context, m *Message) error {
+	headers := map[string]string{"Title": m.Title, "Tags": "white_check_mark"}
+	if m.Summary.failed() {
+		headers["Priority"] = "high"
+		headers["Tags"] = "warning"
+	}
+	if n.token != "" {
+		headers["Authorization"] = "Bearer " + n.token
+	}
+	return post(ctx, n.url, "text/plain", []byte(m.Body), headers)
+}
+
+// gotify posts the message to a Gotify server with an application token.
+type gotify struct {
+	url   string
+	token string
+}
+
+func newGotify(sc SinkConfig) (Sink, error) {
+	if sc.URL == "" || sc.Token == "" {
+		return nil, errors.New("gotify needs a url and an application token")
+	}
+	return &gotify{url: strings.TrimSuffix(sc.URL, "/"), token: sc.Token}, nil
+}
+
+func (g *gotify) Send(ctx context.Context, m *Message) error {
+	priority := 2
+	if m.Summary.failed() {
+		priority = 8
+	}
+	data, err := json.Marshal(map[string]any{"title": m.Title, "message": m.Body, "priority": priority})
+	if err != nil {
+		return err
+	}
+	return post(ctx, g.url+"/message", "application/json", data, map[string]string{"X-Gotify-Key": g.token})
+}
+
+func post(ctx context.Context, url, contentType string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return errors.Errorf("%v: %v", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// smtpSink emails the message. STARTTLS is used when the server offers it.
+type smtpSink struct {
+	addr     string
+	host     string
+	user     string
+	password string
+	from     string
+	to       []string
+}
+
+func newSMTP(sc SinkConfig) (Sink, error) {
+	if sc.Host == "" || sc.From == "" || len(sc.To) == 0 {
+		return nil, errors.New("smtp needs host, from and to")
+	}
+	port := sc.Port
+	if port == 0 {
+		port = 587
+	}
+	return &smtpSink{
+		addr:     net.JoinHostPort(sc.Host, strconv.Itoa(port)),
+		host:     sc.Host,
+		user:     sc.User,
+		password: sc.Password,
+		from:     sc.From,
+		to:       sc.To,
+	}, nil
+}
+
+func (s *smtpSink) Send(_ context.Context, m *Message) error {
+	var auth smtp.Auth
+	if s.user != "" {
+		auth = smtp.PlainAuth("", s.user, s.password, s.host)
+	}
+	msg := &bytes.Buffer{}
+	fmt.Fprintf(msg, "From: %v\r\n", s.from)
+	fmt.Fprintf(msg, "To: %v\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(msg, "Subject: %v\r\n", m.Title)
+	fmt.Fprintf(msg, "Date: %v\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(m.Body, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+	return errors.Wrap(smtp.SendMail(s.addr, auth, s.from, s.to, msg.Bytes()), "sending email")
+}
@@ -0,0 +1,132 @@
+package progress
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ttomsu/gphotobackup/internal/backup"
+)
+
+// window is how far back throughput is measured.
+const window = 30 * time.Second
+
+// Tracker collects progress events from a backup.Session.
+type Tracker struct {
+	mu         sync.Mutex
+	start      time.Time
+	discovered int64
+	downloaded int64
+	skipped    int64
+	failed     int64
+	bytes      int64
+	current    map[int]string
+	samples    []sample
+}
+
+type sample struct {
+	at    time.Time
+	bytes int64
+	done  int64
+}
+
+// NewTracker starts tracking at now.
+func NewTracker() *Tracker {
+	return &Tracker{start: time.Now(), current: map[int]string{}}
+}
+
+func (t *Tracker) Discovered(n int) {
+	t.mu.Lock()
+	t.discovered += int64(n)
+	t.mu.Unlock()
+}
+
+func (t *Tracker) Started(worker int, name string) {
+	t.mu.Lock()
+	t.current[worker] = name
+	t.mu.Unlock()
+}
+
+func (t *Tracker) Transferred(_ int, n int64) {
+	t.mu.Lock()
+	t.bytes += n
+	t.mu.Unlock()
+}
+
+func (t *Tracker) Finished(worker int, result backup.Result) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.current, worker)
+	switch result {
+	case backup.Downloaded:
+		t.downloaded++
+	case backup.Skipped:
+		t.skipped++
+	case backup.Failed:
+		t.failed++
+	}
+}
+
+// Worker is what one worker is downloading.
+type Worker struct {
+	ID   int
+	Item string
+}
+
+// Snapshot is the progress at one moment.
+type Snapshot struct {
+	Discovered, Downloaded, Skipped, Failed int64
+	Bytes                                   int64
+	Elapsed                                 time.Duration
+	// BytesPerSec and ItemsPerSec are averaged over the last 30s.
+	BytesPerSec, ItemsPerSec float64
+	// ETA is zero when it can't be estimated yet.
+	ETA     time.Duration
+	Workers []Worker
+}
+
+// Done is the number of items finished either way.
+func (s Snapshot) Done() int64 {
+	return s.Downloaded + s.Skipped + s.Failed
+}
+
+// Snapshot returns the progress so far. Calling it regularly keeps the
+// throughput estimate current.
+func (t *Tracker) Snapshot() Snapshot {
+	return t.snapshotAt(time.Now())
+}
+
+func (t *Tracker) snapshotAt(now time.Time) Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := Snapshot{
+		Discovered: t.discovered,
+		Downloaded: t.downloaded,
+		Skipped:    t.skipped,
+		Failed:     t.failed,
+		Bytes:      t.bytes,
+		Elapsed:    now.Sub(t.start),
+	}
+	for id, item := range t.current {
+		s.Workers = append(s.Workers, Worker{ID: id, Item: item})
+	}
+	sort.Slice(s.Workers, func(i, j int) bool { return s.Workers[i].ID < s.Workers[j].ID })
+
+	t.samples = append(t.samples, sample{at: now, bytes: s.Bytes, done: s.Done()})
+	for len(t.samples) > 2 && now.Sub(t.samples[1].at) >= window {
+		t.samples = t.samples[1:]
+	}
+	oldest := t.samples[0]
+	if secs := now.Sub(oldest.at).Seconds(); secs > 0 {
+		s.BytesPerSec = float64(s.Bytes-oldest.bytes) / secs
+		s.ItemsPerSec = float64(s.Done()-oldest.done) / secs
+	} else if secs := s.Elapsed.Seconds(); secs > 0 {
+		s.BytesPerSec = float64(s.Bytes) / secs
+		s.ItemsPerSec = float64(s.Done()) / secs
+	}
+	if remaining := s.Discovered - s.Done(); remaining > 0 && s.ItemsPerSec > 0 {
+		s.ETA = time.Duration(float64(remaining) / s.ItemsPerSec * float64(time.Second)).Round(time.Second)
+	}
+	return s
+}
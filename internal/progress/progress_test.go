@@ -0,0 +1,93 @@
+package progress
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gphotosuploader/googlemirror/api/photoslibrary/v1"
+	"github.com/spf13/viper"
+	"github.com/ttomsu/gphotobackup/internal/backup"
+	"github.com/ttomsu/gphotobackup/internal/fakephotos"
+	"github.com/ttomsu/gphotobackup/internal/storage"
+	"go.uber.org/zap"
+)
+
+func TestTracker(t *testing.T) {
+	tr := NewTracker()
+	start := tr.start
+	tr.Discovered(100)
+	tr.snapshotAt(start)
+
+	tr.Started(0, "2021/05/04/a.jpg")
+	tr.Started(1, "2021/05/04/b.jpg")
+	tr.Transferred(0, 10<<20)
+	tr.Finished(0, backup.Downloaded)
+	for i := 0; i < 9; i++ {
+		tr.Finished(2, backup.Skipped)
+	}
+
+	s := tr.snapshotAt(start.Add(10 * time.Second))
+	if s.Done() != 10 || s.Downloaded != 1 || s.Skipped != 9 {
+		t.Errorf("unexpected counts: %+v", s)
+	}
+	if s.BytesPerSec != 1<<20 || s.ItemsPerSec != 1 {
+		t.Errorf("rate = %v B/s, %v items/s", s.BytesPerSec, s.ItemsPerSec)
+	}
+	if s.ETA != 90*time.Second {
+		t.Errorf("ETA = %v, want 1m30s", s.ETA)
+	}
+	if len(s.Workers) != 1 || s.Workers[0].Item != "2021/05/04/b.jpg" {
+		t.Errorf("workers = %+v", s.Workers)
+	}
+
+	// Throughput is measured over the recent window only.
+	s = tr.snapshotAt(start.Add(2 * window))
+	s = tr.snapshotAt(start.Add(2*window + 10*time.Second))
+	if s.BytesPerSec != 0 || s.ETA != 0 {
+		t.Errorf("expected stalled transfer to show no rate or ETA, got %v B/s, ETA %v", s.BytesPerSec, s.ETA)
+	}
+}
+
+func TestTerminalWritesAboveView(t *testing.T) {
+	tr := NewTracker()
+	tr.Discovered(2)
+	out := &bytes.Buffer{}
+	view := NewTerminal(out, tr, func() int { return 0 })
+	view.redraw()
+	out.Reset()
+
+	if _, err := view.Write([]byte("a log line\n")); err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	if !strings.HasPrefix(got, "\x1b[2A\x1b[Ja log line\nItems 0/2") {
+		t.Errorf("expected the view to be cleared, the line printed and the view redrawn, got %q", got)
+	}
+}
+
+func TestTrackerFollowsSession(t *testing.T) {
+	fake := fakephotos.New()
+	defer fake.Close()
+	fake.PageSize = 2
+	viper.Set("api.endpoint", fake.Endpoint())
+	defer viper.Set("api.endpoint", nil)
+	for _, id := range []string{"a", "b", "c"} {
+		fake.Add(fakephotos.Item{ID: id, Filename: id + ".jpg", Created: time.Now(), Data: []byte(id)})
+	}
+
+	bs, err := backup.NewSession(fake.Client(), storage.NewMemory(), 2, zap.NewNop().Sugar())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := NewTracker()
+	bs.SetProgress(tr)
+	if err := bs.Start(&photoslibrary.SearchMediaItemsRequest{PageSize: 100}); err != nil {
+		t.Fatal(err)
+	}
+	s := tr.Snapshot()
+	if s.Discovered != 3 || s.Downloaded != 3 || s.Bytes != 3 {
+		t.Errorf("snapshot = %+v, want 3 discovered and downloaded", s)
+	}
+}
@@ -0,0 +1,126 @@
+package progress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ttomsu/gphotobackup/internal/utils"
+	"go.uber.org/zap"
+)
+
+// Terminal redraws a block of progress lines at the bottom of a terminal.
+// Anything written through it, such as log lines, is printed above the
+// block.
+type Terminal struct {
+	mu      sync.Mutex
+	out     io.Writer
+	tracker *Tracker
+	width   func() int
+	lines   int
+}
+
+// NewTerminal draws t's progress on out, truncating lines to width().
+func NewTerminal(out io.Writer, t *Tracker, width func() int) *Terminal {
+	return &Terminal{out: out, tracker: t, width: width}
+}
+
+// Write prints p above the progress block.
+func (r *Terminal) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clear()
+	n, err := r.out.Write(p)
+	r.draw(r.tracker.Snapshot())
+	return n, err
+}
+
+// Run redraws every interval until ctx is done, then draws a final time.
+func (r *Terminal) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.redraw()
+		case <-ctx.Done():
+			r.redraw()
+			return
+		}
+	}
+}
+
+func (r *Terminal) redraw() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.clear()
+	r.draw(r.tracker.Snapshot())
+}
+
+// clear moves up over the last block and erases it.
+func (r *Terminal) clear() {
+	if r.lines > 0 {
+		fmt.Fprintf(r.out, "\x1b[%dA\x1b[J", r.lines)
+		r.lines = 0
+	}
+}
+
+func (r *Terminal) draw(s Snapshot) {
+	width := r.width()
+	for _, line := range Lines(s) {
+		if width > 0 && len(line) >= width {
+			line = line[:width-1]
+		}
+		fmt.Fprintln(r.out, line)
+		r.lines++
+	}
+}
+
+// Lines formats s for display.
+func Lines(s Snapshot) []string {
+	percent := 0.0
+	if s.Discovered > 0 {
+		percent = 100 * float64(s.Done()) / float64(s.Discovered)
+	}
+	eta := "--"
+	if s.ETA > 0 {
+		eta = s.ETA.String()
+	}
+	lines := []string{
+		fmt.Sprintf("Items %v/%v (%.1f%%)  new %v  skipped %v  failed %v",
+			s.Done(), s.Discovered, percent, s.Downloaded, s.Skipped, s.Failed),
+		fmt.Sprintf("%v/s  %v downloaded  %.1f items/s  elapsed %v  ETA %v",
+			utils.FormatBytes(int64(s.BytesPerSec)), utils.FormatBytes(s.Bytes), s.ItemsPerSec,
+			s.Elapsed.Round(time.Second), eta),
+	}
+	for _, w := range s.Workers {
+		lines = append(lines, fmt.Sprintf("  worker %v: %v", w.ID, w.Item))
+	}
+	return lines
+}
+
+// Log writes a one-line progress summary to logger every interval until
+// ctx is done, for when there's no terminal to draw on.
+func Log(ctx context.Context, logger *zap.SugaredLogger, t *Tracker, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s := t.Snapshot()
+			logger.Infow("Progress: "+strings.SplitN(Lines(s)[0], "  ", 2)[0],
+				"discovered", s.Discovered,
+				"downloaded", s.Downloaded,
+				"skipped", s.Skipped,
+				"failed", s.Failed,
+				"bytes", s.Bytes,
+				"bytesPerSec", int64(s.BytesPerSec),
+				"eta", s.ETA)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package quota
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+)
+
+// ErrExhausted is returned for requests beyond the day's budget.
+var ErrExhausted = errors.New("daily Photos Library API quota exhausted")
+
+// Kind is the quota a request counts against.
+type Kind int
+
+const (
+	// API is a Photos Library API call, such as one page of search results.
+	API Kind = iota
+	// Media is a download from an item's baseUrl.
+	Media
+)
+
+// Google's default daily limits for the Photos Library API.
+const (
+	DefaultAPIPerDay   = 10000
+	DefaultMediaPerDay = 75000
+)
+
+const stateFile = "quota.json"
+
+// saveInterval bounds how much usage is lost if the process is killed.
+const saveInterval = 5 * time.Second
+
+// Usage is the number of requests made on Day.
+type Usage struct {
+	Day   string `json:"day"`
+	API   int64  `json:"api"`
+	Media int64  `json:"media"`
+}
+
+// Limits are the daily budgets. Zero means no limit.
+type Limits struct {
+	API   int64 `json:"api"`
+	Media int64 `json:"media"`
+}
+
+// Tracker counts requests per day in the state dir, refusing those over
+// the day's limits.
+type Tracker struct {
+	mu     sync.Mutex
+	path   string
+	limits Limits
+	usage  Usage
+	saved  time.Time
+	dirty  bool
+	now    func() time.Time
+}
+
+// Open loads today's usage from stateDir.
+func Open(stateDir string, limits Limits) (*Tracker, error) {
+	t := &Tracker{path: filepath.Join(stateDir, stateFile), limits: limits, now: time.Now}
+	data, err := os.ReadFile(t.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.Wrap(err, "reading quota usage")
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &t.usage); err != nil {
+			return nil, errors.Wrapf(err, "parsing %v", t.path)
+		}
+	}
+	return t, nil
+}
+
+// The quota resets at midnight Pacific time.
+var resetZone = func() *time.Location {
+	if loc, err := time.LoadLocation("America/Los_Angeles"); err == nil {
+		return loc
+	}
+	return time.FixedZone("PST", -8*60*60)
+}()
+
+func day(t time.Time) string {
+	return t.In(resetZone).Format("2006-01-02")
+}
+
+// ResetAt is when the current day's quota resets.
+func (t *Tracker) ResetAt() time.Time {
+	now := t.now().In(resetZone)
+	y, m, d := now.Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, resetZone)
+}
+
+// rollover starts a new day's count if the day has changed.
+func (t *Tracker) rollover() {
+	if today := day(t.now()); t.usage.Day != today {
+		t.usage = Usage{Day: today}
+	}
+}
+
+// Take counts one request of kind k, or returns ErrExhausted if the day's
+// budget is used up.
+func (t *Tracker) Take(k Kind) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover()
+	count, limit := &t.usage.API, t.limits.API
+	if k == Media {
+		count, limit = &t.usage.Media, t.limits.Media
+	}
+	if limit > 0 && *count >= limit {
+		return ErrExhausted
+	}
+	*count++
+	t.dirty = true
+	if t.now().Sub(t.saved) >= saveInterval {
+		return t.save()
+	}
+	return nil
+}
+
+// Usage returns today's usage so far.
+func (t *Tracker) Usage() Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollover()
+	return t.usage
+}
+
+// Limits returns the daily budgets.
+func (t *Tracker) Limits() Limits {
+	return t.limits
+}
+
+// Save writes the usage to the state dir.
+func (t *Tracker) Save() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.dirty {
+		return nil
+	}
+	return t.save()
+}
+
+func (t *Tracker) save() error {
+	data, err := json.Marshal(t.usage)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(t.path), 0700); err != nil {
+		return errors.Wrap(err, "creating state dir")
+	}
+	tmp := t.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return errors.Wrap(err, "saving quota usage")
+	}
+	if err := os.Rename(tmp, t.path); err != nil {
+		return errors.Wrap(err, "saving quota usage")
+	}
+	t.saved = t.now()
+	t.dirty = false
+	return nil
+}
+
+// Cost is the number of requests of each kind a run would make.
+type Cost struct {
+	API   int64 `json:"api"`
+	Media int64 `json:"media"`
+}
+
+// Transport rate limits requests and counts them against the tracker.
+type Transport struct {
+	Base    http.RoundTripper
+	Tracker *Tracker
+	// Limiter spaces out requests; nil means no limit.
+	Limiter *rate.Limiter
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Tracker != nil {
+		if err := t.Tracker.Take(KindOf(req)); err != nil {
+			return nil, err
+		}
+	}
+	if t.Limiter != nil {
+		ctx := req.Context()
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		if err := t.Limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(req)
+}
+
+// KindOf tells API calls, which are all under /v1/, from media downloads.
+func KindOf(req *http.Request) Kind {
+	if strings.HasPrefix(req.URL.Path, "/v1/") {
+		return API
+	}
+	return Media
+}
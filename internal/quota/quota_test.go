@@ -0,0 +1,65 @@
+package quota
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTrackerAndTransport(t *testing.T) {
+	dir := t.TempDir()
+	tr, err := Open(dir, Limits{API: 2, Media: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 1, 20, 0, 0, 0, resetZone)
+	tr.now = func() time.Time { return now }
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+	client := &http.Client{Transport: &Transport{Tracker: tr}}
+
+	for _, tc := range []struct {
+		path      string
+		exhausted bool
+	}{
+		{"/v1/mediaItems:search", false},
+		{"/lr/photo=d", false},
+		{"/v1/albums", false},
+		{"/v1/albums", true},
+		{"/lr/other=d", true},
+	} {
+		resp, err := client.Get(ts.URL + tc.path)
+		if got := errors.Is(err, ErrExhausted); got != tc.exhausted {
+			t.Fatalf("GET %v: exhausted = %v, err = %v", tc.path, got, err)
+		}
+		if err == nil {
+			_ = resp.Body.Close()
+		}
+	}
+	if u := tr.Usage(); u.API != 2 || u.Media != 1 || u.Day != "2024-05-01" {
+		t.Errorf("usage = %+v", u)
+	}
+	if err := tr.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(dir, Limits{API: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened.now = func() time.Time { return now }
+	if err := reopened.Take(API); !errors.Is(err, ErrExhausted) {
+		t.Errorf("expected usage to persist, got %v", err)
+	}
+	if want := time.Date(2024, 5, 2, 0, 0, 0, 0, resetZone); !reopened.ResetAt().Equal(want) {
+		t.Errorf("ResetAt = %v, want %v", reopened.ResetAt(), want)
+	}
+
+	now = now.Add(5 * time.Hour)
+	if err := reopened.Take(API); err != nil {
+		t.Errorf("expected a fresh budget the next day, got %v", err)
+	}
+}
@@ -0,0 +1,266 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+	"github.com/zalando/go-keyring"
+	"golang.org/x/oauth2"
+)
+
+const (
+	keyringService   = "gphotobackup"
+	PassphraseEnvVar = "GPHOTOBACKUP_SECRETS_PASSPHRASE"
+)
+
+// scryptWorkFactor is age's default; tests lower it.
+var scryptWorkFactor = 18
+
+// SecretStore holds credentials such as the OAuth token. Load returns an
+// fs.ErrNotExist-compatible error when the secret hasn't been saved.
+type SecretStore interface {
+	Name() string
+	Load(name string) ([]byte, error)
+	Save(name string, data []byte) error
+	Delete(name string) error
+}
+
+// OpenSecretStore returns the store selected by the secrets.backend setting:
+// "keyring", "file" (age-encrypted with a passphrase), "plain", or "auto",
+// which picks the keyring when available, then the encrypted file when a
+// passphrase is configured, then plain files.
+func OpenSecretStore() (SecretStore, error) {
+	switch backend := viper.GetString("secrets.backend"); backend {
+	case "", "auto":
+		if keyringAvailable() {
+			return keyringStore{}, nil
+		}
+		if pass, err := secretsPassphrase(); err != nil {
+			return nil, err
+		} else if pass != "" {
+			return encryptedFileStore{passphrase: pass}, nil
+		}
+		return plainStore{}, nil
+	case "keyring":
+		if !keyringAvailable() {
+			return nil, errors.New("no OS keyring or Secret Service available")
+		}
+		return keyringStore{}, nil
+	case "file":
+		pass, err := secretsPassphrase()
+		if err != nil {
+			return nil, err
+		}
+		if pass == "" {
+			return nil, errors.Errorf("secrets.backend=file needs a passphrase in $%v or secrets.passphraseFile", PassphraseEnvVar)
+		}
+		return encryptedFileStore{passphrase: pass}, nil
+	case "plain":
+		return plainStore{}, nil
+	default:
+		return nil, errors.Errorf("unknown secrets.backend %q", backend)
+	}
+}
+
+// secretsPassphrase reads the passphrase for the encrypted file store from
+// the environment or secrets.passphraseFile, returning "" if neither is set.
+func secretsPassphrase() (string, error) {
+	if pass := os.Getenv(PassphraseEnvVar); pass != "" {
+		return pass, nil
+	}
+	file := viper.GetString("secrets.passphraseFile")
+	if file == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", errors.Wrap(err, "reading secrets.passphraseFile")
+	}
+	pass := strings.TrimRight(string(data), "\r\n")
+	if pass == "" {
+		return "", errors.Errorf("passphrase file %v is empty", file)
+	}
+	return pass, nil
+}
+
+// keyringAvailable probes the keyring; a missing entry means it works.
+func keyringAvailable() bool {
+	_, err := keyring.Get(keyringService, "probe")
+	return err == nil || errors.Is(err, keyring.ErrNotFound)
+}
+
+type plainStore struct{}
+
+func (plainStore) Name() string { return "plain" }
+
+func (plainStore) Load(name string) ([]byte, error) {
+	return ReadFromConfigDir(name)
+}
+
+func (plainStore) Save(name string, data []byte) error {
+	return WriteToConfigDir(name, data)
+}
+
+func (plainStore) Delete(name string) error {
+	return removeFromConfigDir(name)
+}
+
+// keyringStore keeps secrets in the OS keyring, keyed by profile.
+type keyringStore struct{}
+
+func (keyringStore) Name() string { return "keyring" }
+
+func (keyringStore) Load(name string) ([]byte, error) {
+	s, err := keyring.Get(keyringService, keyringUser(currentProfile, name))
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil, &fs.PathError{Op: "load", Path: name, Err: fs.ErrNotExist}
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "reading %v from keyring", name)
+	}
+	return []byte(s), nil
+}
+
+func (keyringStore) Save(name string, data []byte) error {
+	return errors.Wrapf(keyring.Set(keyringService, keyringUser(currentProfile, name), string(data)),
+		"saving %v to keyring", name)
+}
+
+func (keyringStore) Delete(name string) error {
+	err := keyring.Delete(keyringService, keyringUser(currentProfile, name))
+	if err != nil && !errors.Is(err, keyring.ErrNotFound) {
+		return errors.Wrapf(err, "deleting %v from keyring", name)
+	}
+	return nil
+}
+
+func keyringUser(profile, name string) string {
+	if profile == "" {
+		profile = DefaultProfile
+	}
+	return profile + "/" + name
+}
+
+// encryptedFileStore keeps secrets in the config dir, encrypted with age
+// using a scrypt passphrase.
+type encryptedFileStore struct {
+	passphrase string
+}
+
+func (encryptedFileStore) Name() string { return "file" }
+
+func (s encryptedFileStore) Load(name string) ([]byte, error) {
+	data, err := ReadFromConfigDir(name + ".age")
+	if err != nil {
+		return nil, err
+	}
+	id, err := age.NewScryptIdentity(s.passphrase)
+	if err != nil {
+		return nil, err
+	}
+	r, err := age.Decrypt(bytes.NewReader(data), id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decrypting %v, is the passphrase right?", name)
+	}
+	return io.ReadAll(r)
+}
+
+func (s encryptedFileStore) Save(name string, data []byte) error {
+	rcpt, err := age.NewScryptRecipient(s.passphrase)
+	if err != nil {
+		return err
+	}
+	rcpt.SetWorkFactor(scryptWorkFactor)
+	buf := &bytes.Buffer{}
+	w, err := age.Encrypt(buf, rcpt)
+	if err != nil {
+		return errors.Wrapf(err, "encrypting %v", name)
+	}
+	if _, err := w.Write(data); err != nil {
+		return errors.Wrapf(err, "encrypting %v", name)
+	}
+	if err := w.Close(); err != nil {
+		return errors.Wrapf(err, "encrypting %v", name)
+	}
+	return WriteToConfigDir(name+".age", buf.Bytes())
+}
+
+func (encryptedFileStore) Delete(name string) error {
+	return removeFromConfigDir(name + ".age")
+}
+
+// LoadToken reads the saved OAuth token, falling back to a plaintext
+// token.json left from before the secret store was configured. It returns
+// the store the token came from, so refreshed tokens are saved back there.
+func LoadToken() ([]byte, SecretStore, error) {
+	store, err := OpenSecretStore()
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := store.Load(TokenFilename)
+	if errors.Is(err, fs.ErrNotExist) && store.Name() != "plain" {
+		if plain, perr := (plainStore{}).Load(TokenFilename); perr == nil {
+			return plain, plainStore{}, nil
+		}
+	}
+	return data, store, err
+}
+
+// SaveToken writes the OAuth token to the configured secret store, removing
+// any plaintext token.json it replaces.
+func SaveToken(token *oauth2.Token) error {
+	store, err := OpenSecretStore()
+	if err != nil {
+		return err
+	}
+	if err := saveToken(store, token); err != nil {
+		return err
+	}
+	if store.Name() != "plain" {
+		return (plainStore{}).Delete(TokenFilename)
+	}
+	return nil
+}
+
+func saveToken(store SecretStore, token *oauth2.Token) error {
+	data, err := json.MarshalIndent(token, "", "\t")
+	if err != nil {
+		return errors.Wrap(err, "marshalling token")
+	}
+	return store.Save(TokenFilename, data)
+}
+
+// MigrateToken moves a plaintext token.json into the given store, removing
+// the plaintext copy once the new one reads back correctly.
+func MigrateToken(to SecretStore) error {
+	data, err := (plainStore{}).Load(TokenFilename)
+	if err != nil {
+		return errors.Wrap(err, "reading plaintext token.json")
+	}
+	if err := to.Save(TokenFilename, data); err != nil {
+		return err
+	}
+	check, err := to.Load(TokenFilename)
+	if err != nil {
+		return errors.Wrap(err, "verifying migrated token")
+	}
+	if !bytes.Equal(check, data) {
+		return errors.New("migrated token doesn't match, keeping token.json")
+	}
+	return (plainStore{}).Delete(TokenFilename)
+}
+
+func removeFromConfigDir(filename string) error {
+	err := os.Remove(filepath.Join(gphotobackupConfigDir, filename))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "removing %v", filename)
+	}
+	return nil
+}
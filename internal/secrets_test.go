@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/zalando/go-keyring"
+	"golang.org/x/oauth2"
+)
+
+// tempConfigDir points the config dir at a temporary dir for the test.
+func tempConfigDir(t *testing.T) {
+	old := gphotobackupConfigDir
+	t.Cleanup(func() { gphotobackupConfigDir = old })
+	gphotobackupConfigDir = t.TempDir()
+}
+
+func TestEncryptedFileStore(t *testing.T) {
+	tempConfigDir(t)
+	oldWorkFactor := scryptWorkFactor
+	t.Cleanup(func() { scryptWorkFactor = oldWorkFactor })
+	scryptWorkFactor = 10
+
+	s := encryptedFileStore{passphrase: "correct horse"}
+	if _, err := s.Load(TokenFilename); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected not-exist, got: %v", err)
+	}
+	if err := s.Save(TokenFilename, []byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := ReadFromConfigDir(TokenFilename + ".age"); err != nil || string(plain) == "secret" {
+		t.Fatalf("expected ciphertext on disk, got %q, %v", plain, err)
+	}
+	if got, err := s.Load(TokenFilename); err != nil || string(got) != "secret" {
+		t.Fatalf("Load = %q, %v", got, err)
+	}
+	if _, err := (encryptedFileStore{passphrase: "wrong"}).Load(TokenFilename); err == nil {
+		t.Fatal("expected wrong passphrase to fail")
+	}
+}
+
+func TestMigrateToken(t *testing.T) {
+	tempConfigDir(t)
+	keyring.MockInit()
+	defer viper.Set("secrets.backend", nil)
+
+	viper.Set("secrets.backend", "plain")
+	if err := SaveToken(&oauth2.Token{RefreshToken: "refresh"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// An unmigrated token.json is still found once the keyring is in use.
+	viper.Set("secrets.backend", "keyring")
+	if _, store, err := LoadToken(); err != nil || store.Name() != "plain" {
+		t.Fatalf("LoadToken before migrating = %v, %v", store, err)
+	}
+
+	if err := MigrateToken(keyringStore{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadFromConfigDir(TokenFilename); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected plaintext token.json to be removed, got: %v", err)
+	}
+	data, store, err := LoadToken()
+	if err != nil || store.Name() != "keyring" {
+		t.Fatalf("LoadToken after migrating = %v, %v", store, err)
+	}
+	if len(data) == 0 {
+		t.Fatal("empty token from keyring")
+	}
+}
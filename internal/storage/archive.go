@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ManifestName is the last entry of every archive, listing the SHA-256 of the
+// other entries in the format used by sha256sum.
+const ManifestName = "MANIFEST.sha256"
+
+var errArchiveUnsupported = errors.New("not supported when writing an archive")
+
+// Archive is a write-only Storage that streams files into a tar or zip
+// archive. Files are buffered in a temp dir until they're complete, so
+// concurrent writers don't interleave.
+type Archive struct {
+	mu       sync.Mutex
+	tw       *tar.Writer
+	zw       *zip.Writer
+	files    map[string]*FileInfo
+	manifest []string
+}
+
+// NewArchive returns an Archive writing to w in format "tar" or "zip". Close
+// must be called to write the manifest and finish the archive.
+func NewArchive(w io.Writer, format string) (*Archive, error) {
+	a := &Archive{files: make(map[string]*FileInfo)}
+	switch format {
+	case "tar":
+		a.tw = tar.NewWriter(w)
+	case "zip":
+		a.zw = zip.NewWriter(w)
+	default:
+		return nil, errors.Errorf("unsupported archive format %q", format)
+	}
+	return a, nil
+}
+
+func (a *Archive) Stat(name string) (*FileInfo, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if fi, ok := a.files[path.Clean(name)]; ok {
+		return fi, nil
+	}
+	return nil, notExist("stat", name)
+}
+
+func (a *Archive) Open(string) (io.ReadCloser, error) {
+	return nil, errArchiveUnsupported
+}
+
+func (a *Archive) Create(name string, modTime time.Time) (Writer, error) {
+	f, err := os.CreateTemp("", "gphotobackup-archive-*")
+	if err != nil {
+		return nil, errors.Wrap(err, "creating archive buffer")
+	}
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	return &archiveWriter{File: f, a: a, name: path.Clean(name), modTime: modTime}, nil
+}
+
+func (a *Archive) Rename(string, string) error {
+	return errArchiveUnsupported
+}
+
+func (a *Archive) List(dir string) ([]string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var names []string
+	for name := range a.files {
+		if path.Dir(name) == path.Clean(dir) {
+			names = append(names, path.Base(name))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (a *Archive) Link(string, string) error {
+	return errArchiveUnsupported
+}
+
+func (a *Archive) Remove(string) error {
+	return errArchiveUnsupported
+}
+
+// MkdirAll is a no-op, as entries carry their full path.
+func (a *Archive) MkdirAll(string) error {
+	return nil
+}
+
+// Close writes the manifest and finishes the archive, but does not close the
+// underlying writer.
+func (a *Archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var manifest []byte
+	for _, line := range a.manifest {
+		manifest = append(manifest, line...)
+	}
+	w, err := a.entry(ManifestName, int64(len(manifest)), time.Now())
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(manifest); err != nil {
+		return errors.Wrap(err, "writing manifest")
+	}
+	if a.tw != nil {
+		return a.tw.Close()
+	}
+	return a.zw.Close()
+}
+
+// entry starts a new entry in the archive. a.mu must be held.
+func (a *Archive) entry(name string, size int64, modTime time.Time) (io.Writer, error) {
+	if a.tw != nil {
+		err := a.tw.WriteHeader(&tar.Header{
+			Typeflag: tar.TypeReg,
+			Name:     name,
+			Size:     size,
+			Mode:     0644,
+			ModTime:  modTime,
+		})
+		return a.tw, errors.Wrapf(err, "writing header for %v", name)
+	}
+	// Photos and videos are compressed already.
+	w, err := a.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: modTime,
+	})
+	return w, errors.Wrapf(err, "writing header for %v", name)
+}
+
+type archiveWriter struct {
+	*os.File
+	a       *Archive
+	name    string
+	modTime time.Time
+}
+
+func (aw *archiveWriter) Close() error {
+	defer os.Remove(aw.Name())
+	defer aw.File.Close()
+	size, err := aw.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := aw.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	aw.a.mu.Lock()
+	defer aw.a.mu.Unlock()
+	w, err := aw.a.entry(aw.name, size, aw.modTime)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(w, hash), aw.File); err != nil {
+		return errors.Wrapf(err, "writing %v to archive", aw.name)
+	}
+	aw.a.files[aw.name] = &FileInfo{Name: path.Base(aw.name), Size: size, ModTime: aw.modTime}
+	aw.a.manifest = append(aw.a.manifest, fmt.Sprintf("%v  %v\n", hex.EncodeToString(hash.Sum(nil)), aw.name))
+	return nil
+}
+
+func (aw *archiveWriter) Abort() error {
+	_ = aw.File.Close()
+	return os.Remove(aw.Name())
+}
+
+// volumeWriter splits what is written across files of at most size bytes,
+// named base.001, base.002, etc. They can be rejoined with cat.
+type volumeWriter struct {
+	base string
+	size int64
+	n    int
+	cur  *os.File
+	left int64
+}
+
+// NewVolumeWriter returns a writer to the file base, or to numbered volumes of
+// at most size bytes if size is positive.
+func NewVolumeWriter(base string, size int64) (io.WriteCloser, error) {
+	if size <= 0 {
+		return os.Create(base)
+	}
+	return &volumeWriter{base: base, size: size}, nil
+}
+
+func (vw *volumeWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if vw.cur == nil || vw.left == 0 {
+			if err := vw.next(); err != nil {
+				return written, err
+			}
+		}
+		chunk := p
+		if int64(len(chunk)) > vw.left {
+			chunk = chunk[:vw.left]
+		}
+		n, err := vw.cur.Write(chunk)
+		written += n
+		vw.left -= int64(n)
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func (vw *volumeWriter) next() error {
+	if err := vw.Close(); err != nil {
+		return err
+	}
+	vw.n++
+	f, err := os.Create(fmt.Sprintf("%v.%03d", vw.base, vw.n))
+	if err != nil {
+		return errors.Wrap(err, "creating volume")
+	}
+	vw.cur, vw.left = f, vw.size
+	return nil
+}
+
+func (vw *volumeWriter) Close() error {
+	if vw.cur == nil {
+		return nil
+	}
+	err := vw.cur.Close()
+	vw.cur = nil
+	return err
+}
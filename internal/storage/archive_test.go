@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestArchive(t *testing.T) {
+	base := filepath.Join(t.TempDir(), "2022.tar")
+	out, err := NewVolumeWriter(base, 1024)
+	if err != nil {
+		t.Fatalf("volume writer: %v", err)
+	}
+	a, err := NewArchive(out, "tar")
+	if err != nil {
+		t.Fatalf("new archive: %v", err)
+	}
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w, err := a.Create(fmt.Sprintf("2022/01/0%v/item.jpg", i), time.Now())
+			if err != nil {
+				t.Errorf("create: %v", err)
+				return
+			}
+			_, _ = w.Write(bytes.Repeat([]byte{byte(i)}, 500))
+			if err := w.Close(); err != nil {
+				t.Errorf("close: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if _, err := a.Stat("2022/01/03/item.jpg"); err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("close archive: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		t.Fatalf("close volumes: %v", err)
+	}
+
+	volumes, _ := filepath.Glob(base + ".*")
+	if len(volumes) < 3 {
+		t.Fatalf("expected the archive to be split, got volumes: %v", volumes)
+	}
+	var joined []io.Reader
+	for _, v := range volumes {
+		f, err := os.Open(v)
+		if err != nil {
+			t.Fatalf("open volume: %v", err)
+		}
+		defer f.Close()
+		joined = append(joined, f)
+	}
+
+	sums := map[string]string{}
+	var manifest string
+	tr := tar.NewReader(io.MultiReader(joined...))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("reading tar: %v", err)
+		}
+		data, _ := io.ReadAll(tr)
+		if hdr.Name == ManifestName {
+			manifest = string(data)
+			continue
+		}
+		sum := sha256.Sum256(data)
+		sums[hdr.Name] = hex.EncodeToString(sum[:])
+	}
+	if len(sums) != 5 {
+		t.Fatalf("expected 5 entries, got: %v", sums)
+	}
+	for name, sum := range sums {
+		if !strings.Contains(manifest, sum+"  "+name+"\n") {
+			t.Fatalf("manifest missing %v %v:\n%v", sum, name, manifest)
+		}
+	}
+}
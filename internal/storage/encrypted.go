@@ -0,0 +1,191 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/pkg/errors"
+)
+
+// EncryptedSuffix is appended to the name of every file written through an
+// encrypted Storage.
+const EncryptedSuffix = ".age"
+
+// PassphraseKeyName holds the key a passphrase-encrypted backup is encrypted
+// to, itself encrypted with the passphrase. Without EncryptedSuffix, it isn't
+// visible through the encrypted Storage.
+const PassphraseKeyName = ".gphotobackup.key"
+
+// scryptWorkFactor is age's default; tests lower it.
+var scryptWorkFactor = 18
+
+type encrypted struct {
+	Storage
+	recipients []age.Recipient
+	identities []age.Identity
+}
+
+// NewEncrypted returns a Storage that age-encrypts everything written to inner
+// for recipients, and decrypts what is read using identities. Only directories
+// and files with EncryptedSuffix are visible through it.
+func NewEncrypted(inner Storage, recipients []age.Recipient, identities []age.Identity) Storage {
+	return &encrypted{Storage: inner, recipients: recipients, identities: identities}
+}
+
+func (e *encrypted) Stat(name string) (*FileInfo, error) {
+	fi, err := e.Storage.Stat(name + EncryptedSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		if dir, dirErr := e.Storage.Stat(name); dirErr == nil && dir.IsDir {
+			return dir, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	fi.Name = strings.TrimSuffix(fi.Name, EncryptedSuffix)
+	return fi, nil
+}
+
+func (e *encrypted) Open(name string) (io.ReadCloser, error) {
+	if len(e.identities) == 0 {
+		return nil, errors.New("no identities to decrypt with")
+	}
+	in, err := e.Storage.Open(name + EncryptedSuffix)
+	if err != nil {
+		return nil, err
+	}
+	dec, err := age.Decrypt(in, e.identities...)
+	if err != nil {
+		_ = in.Close()
+		return nil, errors.Wrapf(err, "age decrypt %v", name)
+	}
+	return &decryptedReader{Reader: dec, Closer: in}, nil
+}
+
+func (e *encrypted) Create(name string, modTime time.Time) (Writer, error) {
+	out, err := e.Storage.Create(name+EncryptedSuffix, modTime)
+	if err != nil {
+		return nil, err
+	}
+	enc, err := age.Encrypt(out, e.recipients...)
+	if err != nil {
+		_ = out.Abort()
+		return nil, errors.Wrap(err, "age encrypt")
+	}
+	return &encryptedWriter{enc: enc, out: out}, nil
+}
+
+func (e *encrypted) Rename(oldName, newName string) error {
+	return e.Storage.Rename(oldName+EncryptedSuffix, newName+EncryptedSuffix)
+}
+
+func (e *encrypted) List(dir string) ([]string, error) {
+	names, err := e.Storage.List(dir)
+	if err != nil {
+		return nil, err
+	}
+	var visible []string
+	for _, name := range names {
+		if strings.HasSuffix(name, EncryptedSuffix) {
+			visible = append(visible, strings.TrimSuffix(name, EncryptedSuffix))
+		} else if fi, err := e.Storage.Stat(path.Join(dir, name)); err == nil && fi.IsDir {
+			visible = append(visible, name)
+		}
+	}
+	return visible, nil
+}
+
+func (e *encrypted) Link(oldName, newName string) error {
+	return e.Storage.Link(oldName+EncryptedSuffix, newName+EncryptedSuffix)
+}
+
+func (e *encrypted) Remove(name string) error {
+	return e.Storage.Remove(name + EncryptedSuffix)
+}
+
+type decryptedReader struct {
+	io.Reader
+	io.Closer
+}
+
+type encryptedWriter struct {
+	enc io.WriteCloser
+	out Writer
+}
+
+func (ew *encryptedWriter) Write(p []byte) (int, error) {
+	return ew.enc.Write(p)
+}
+
+func (ew *encryptedWriter) Close() error {
+	if err := ew.enc.Close(); err != nil {
+		_ = ew.out.Abort()
+		return errors.Wrap(err, "age encrypt")
+	}
+	return ew.out.Close()
+}
+
+func (ew *encryptedWriter) Abort() error {
+	return ew.out.Abort()
+}
+
+// PassphraseKey returns the X25519 identity that the passphrase-encrypted
+// backup in s is encrypted to, creating it if create is set and there is none
+// yet. Scrypt is deliberately slow, so it's only run once, on this key, rather
+// than for every file.
+func PassphraseKey(s Storage, passphrase string, create bool) (*age.X25519Identity, error) {
+	scrypt, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, errors.Wrap(err, "passphrase identity")
+	}
+	r, err := s.Open(PassphraseKeyName)
+	if err == nil {
+		defer r.Close()
+		dec, err := age.Decrypt(r, scrypt)
+		if err != nil {
+			return nil, errors.Wrapf(err, "decrypting %v", PassphraseKeyName)
+		}
+		data, err := io.ReadAll(dec)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %v", PassphraseKeyName)
+		}
+		return age.ParseX25519Identity(strings.TrimSpace(string(data)))
+	} else if !errors.Is(err, fs.ErrNotExist) || !create {
+		return nil, err
+	}
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		return nil, errors.Wrap(err, "generating key")
+	}
+	rcpt, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, errors.Wrap(err, "passphrase recipient")
+	}
+	rcpt.SetWorkFactor(scryptWorkFactor)
+	buf := &bytes.Buffer{}
+	enc, err := age.Encrypt(buf, rcpt)
+	if err != nil {
+		return nil, errors.Wrap(err, "age encrypt")
+	}
+	if _, err := io.WriteString(enc, identity.String()+"\n"); err != nil {
+		return nil, errors.Wrap(err, "age encrypt")
+	}
+	if err := enc.Close(); err != nil {
+		return nil, errors.Wrap(err, "age encrypt")
+	}
+	out, err := s.Create(PassphraseKeyName, time.Time{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating %v", PassphraseKeyName)
+	}
+	if _, err := out.Write(buf.Bytes()); err != nil {
+		_ = out.Abort()
+		return nil, errors.Wrapf(err, "writing %v", PassphraseKeyName)
+	}
+	return identity, errors.Wrapf(out.Close(), "committing %v", PassphraseKeyName)
+}
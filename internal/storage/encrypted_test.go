@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+)
+
+func TestEncrypted(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("generate identity: %v", err)
+	}
+	inner := NewMemory()
+	s := NewEncrypted(inner, []age.Recipient{identity.Recipient()}, []age.Identity{identity})
+
+	if err := s.MkdirAll("2021/05/04"); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	w, err := s.Create("2021/05/04/a.jpg", time.Now())
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	_, _ = w.Write([]byte("hello"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	raw, err := inner.ReadFile("2021/05/04/a.jpg" + EncryptedSuffix)
+	if err != nil {
+		t.Fatalf("read raw: %v", err)
+	}
+	if strings.Contains(string(raw), "hello") {
+		t.Fatalf("expected encrypted contents, got: %q", raw)
+	}
+	if got := readAll(t, s, "2021/05/04/a.jpg"); got != "hello" {
+		t.Fatalf("expected: hello, got: %v", got)
+	}
+	if _, err := s.Stat("2021/05/04/a.jpg"); err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+
+	var walked []string
+	err = Walk(s, ".", func(name string, _ *FileInfo) error {
+		walked = append(walked, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk: %v", err)
+	}
+	if want := []string{"2021/05/04/a.jpg"}; !reflect.DeepEqual(want, walked) {
+		t.Fatalf("expected: %v, got: %v", want, walked)
+	}
+}
+
+func TestPassphraseKey(t *testing.T) {
+	old := scryptWorkFactor
+	t.Cleanup(func() { scryptWorkFactor = old })
+	scryptWorkFactor = 10
+
+	inner := NewMemory()
+	if _, err := PassphraseKey(inner, "correct horse", false); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected no key yet, got: %v", err)
+	}
+	key, err := PassphraseKey(inner, "correct horse", true)
+	if err != nil {
+		t.Fatalf("create key: %v", err)
+	}
+	again, err := PassphraseKey(inner, "correct horse", true)
+	if err != nil || again.String() != key.String() {
+		t.Fatalf("expected the saved key, got: %v, %v", again, err)
+	}
+	if _, err := PassphraseKey(inner, "wrong", false); err == nil {
+		t.Fatal("expected the wrong passphrase to fail")
+	}
+	if names, err := NewEncrypted(inner, nil, nil).List("."); err != nil || len(names) != 0 {
+		t.Errorf("expected the key to be hidden, got: %v, %v", names, err)
+	}
+}
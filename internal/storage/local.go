@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type local struct {
+	root string
+}
+
+// NewLocal returns a Storage backed by the local filesystem under root.
+func NewLocal(root string) Storage {
+	return &local{root: root}
+}
+
+func (l *local) path(name string) string {
+	return filepath.Join(l.root, filepath.FromSlash(name))
+}
+
+func (l *local) Stat(name string) (*FileInfo, error) {
+	fi, err := os.Stat(l.path(name))
+	if err != nil {
+		return nil, err
+	}
+	return &FileInfo{
+		Name:    fi.Name(),
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		IsDir:   fi.IsDir(),
+	}, nil
+}
+
+func (l *local) Open(name string) (io.ReadCloser, error) {
+	return os.Open(l.path(name))
+}
+
+func (l *local) Create(name string, modTime time.Time) (Writer, error) {
+	dest := l.path(name)
+	f, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".*"+partialSuffix)
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating temp file for %v", name)
+	}
+	return &localWriter{File: f, dest: dest, modTime: modTime}, nil
+}
+
+func (l *local) Rename(oldName, newName string) error {
+	return os.Rename(l.path(oldName), l.path(newName))
+}
+
+func (l *local) List(dir string) ([]string, error) {
+	f, err := os.Open(l.path(dir))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(-1)
+	return filterPartial(names), err
+}
+
+func (l *local) Link(oldName, newName string) error {
+	return os.Link(l.path(oldName), l.path(newName))
+}
+
+func (l *local) Remove(name string) error {
+	return os.Remove(l.path(name))
+}
+
+func (l *local) MkdirAll(dir string) error {
+	return os.MkdirAll(l.path(dir), 0755)
+}
+
+type localWriter struct {
+	*os.File
+	dest    string
+	modTime time.Time
+}
+
+func (lw *localWriter) Close() error {
+	if err := lw.File.Chmod(0644); err != nil {
+		_ = lw.Abort()
+		return errors.Wrap(err, "chmod")
+	}
+	if err := lw.File.Close(); err != nil {
+		_ = os.Remove(lw.Name())
+		return err
+	}
+	if !lw.modTime.IsZero() {
+		if err := os.Chtimes(lw.Name(), lw.modTime, lw.modTime); err != nil {
+			_ = os.Remove(lw.Name())
+			return errors.Wrap(err, "error changing times")
+		}
+	}
+	if err := os.Rename(lw.Name(), lw.dest); err != nil {
+		_ = os.Remove(lw.Name())
+		return errors.Wrapf(err, "renaming to %v", lw.dest)
+	}
+	return nil
+}
+
+func (lw *localWriter) Abort() error {
+	_ = lw.File.Close()
+	return os.Remove(lw.Name())
+}
@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Memory is an in-memory Storage, intended for tests.
+type Memory struct {
+	mu    sync.Mutex
+	files map[string]*memFile
+	dirs  map[string]bool
+}
+
+type memFile struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		files: make(map[string]*memFile),
+		dirs:  map[string]bool{".": true},
+	}
+}
+
+// ReadFile returns the contents of the named file.
+func (m *Memory) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, ok := m.files[path.Clean(name)]
+	if !ok {
+		return nil, notExist("read", name)
+	}
+	return f.data, nil
+}
+
+func (m *Memory) Stat(name string) (*FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = path.Clean(name)
+	if f, ok := m.files[name]; ok {
+		return &FileInfo{Name: path.Base(name), Size: int64(len(f.data)), ModTime: f.modTime}, nil
+	}
+	if m.dirs[name] {
+		return &FileInfo{Name: path.Base(name), IsDir: true}, nil
+	}
+	return nil, notExist("stat", name)
+}
+
+func (m *Memory) Open(name string) (io.ReadCloser, error) {
+	data, err := m.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (m *Memory) Create(name string, modTime time.Time) (Writer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = path.Clean(name)
+	if !m.dirs[path.Dir(name)] {
+		return nil, notExist("create", name)
+	}
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	return &memWriter{m: m, name: name, modTime: modTime}, nil
+}
+
+func (m *Memory) Rename(oldName, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldName, newName = path.Clean(oldName), path.Clean(newName)
+	f, ok := m.files[oldName]
+	if !ok {
+		return notExist("rename", oldName)
+	}
+	if !m.dirs[path.Dir(newName)] {
+		return notExist("rename", newName)
+	}
+	delete(m.files, oldName)
+	m.files[newName] = f
+	return nil
+}
+
+func (m *Memory) List(dir string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dir = path.Clean(dir)
+	if !m.dirs[dir] {
+		return nil, notExist("list", dir)
+	}
+	var names []string
+	for name := range m.files {
+		if path.Dir(name) == dir {
+			names = append(names, path.Base(name))
+		}
+	}
+	for name := range m.dirs {
+		if name != "." && path.Dir(name) == dir {
+			names = append(names, path.Base(name))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (m *Memory) Link(oldName, newName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	oldName, newName = path.Clean(oldName), path.Clean(newName)
+	f, ok := m.files[oldName]
+	if !ok {
+		return notExist("link", oldName)
+	}
+	if !m.dirs[path.Dir(newName)] {
+		return notExist("link", newName)
+	}
+	if _, ok := m.files[newName]; ok {
+		return &fs.PathError{Op: "link", Path: newName, Err: fs.ErrExist}
+	}
+	m.files[newName] = f
+	return nil
+}
+
+func (m *Memory) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	name = path.Clean(name)
+	if _, ok := m.files[name]; ok {
+		delete(m.files, name)
+		return nil
+	}
+	if m.dirs[name] {
+		prefix := name + "/"
+		for n := range m.files {
+			if strings.HasPrefix(n, prefix) {
+				return errors.Errorf("directory %v not empty", name)
+			}
+		}
+		for n := range m.dirs {
+			if strings.HasPrefix(n, prefix) {
+				return errors.Errorf("directory %v not empty", name)
+			}
+		}
+		delete(m.dirs, name)
+		return nil
+	}
+	return notExist("remove", name)
+}
+
+func (m *Memory) MkdirAll(dir string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for dir = path.Clean(dir); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if _, ok := m.files[dir]; ok {
+			return errors.Errorf("%v is a file", dir)
+		}
+		m.dirs[dir] = true
+	}
+	return nil
+}
+
+type memWriter struct {
+	bytes.Buffer
+	m       *Memory
+	name    string
+	modTime time.Time
+	done    bool
+}
+
+func (mw *memWriter) Close() error {
+	if mw.done {
+		return errors.New("writer already closed")
+	}
+	mw.done = true
+	mw.m.mu.Lock()
+	defer mw.m.mu.Unlock()
+	mw.m.files[mw.name] = &memFile{data: mw.Bytes(), modTime: mw.modTime}
+	return nil
+}
+
+func (mw *memWriter) Abort() error {
+	mw.done = true
+	return nil
+}
+
+func notExist(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
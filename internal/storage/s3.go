@@ -0,0 +1,208 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/pkg/errors"
+)
+
+const (
+	s3MtimeMeta = "Mtime"
+	s3PartSize  = 16 << 20
+)
+
+type S3Options struct {
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+	Insecure  bool
+
+	// Transport overrides the HTTP transport, e.g. to trust a private CA.
+	Transport http.RoundTripper
+}
+
+type s3Storage struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// NewS3 returns a Storage that writes objects to bucket under prefix. If no
+// keys are given, the standard AWS_* and MINIO_* environment variables are used.
+func NewS3(bucket, prefix string, opts S3Options) (Storage, error) {
+	if opts.Endpoint == "" {
+		opts.Endpoint = "s3.amazonaws.com"
+	}
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.Static{Value: credentials.Value{
+			AccessKeyID:     opts.AccessKey,
+			SecretAccessKey: opts.SecretKey,
+			SignerType:      credentials.SignatureV4,
+		}},
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+	})
+	client, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:     creds,
+		Secure:    !opts.Insecure,
+		Region:    opts.Region,
+		Transport: opts.Transport,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "s3 client")
+	}
+	return &s3Storage{
+		client: client,
+		bucket: bucket,
+		prefix: strings.Trim(prefix, "/"),
+	}, nil
+}
+
+func (s *s3Storage) key(name string) string {
+	key := path.Join(s.prefix, name)
+	if key == "." {
+		// The root of a bare bucket URL.
+		return ""
+	}
+	return strings.TrimPrefix(key, "/")
+}
+
+func (s *s3Storage) Stat(name string) (*FileInfo, error) {
+	info, err := s.client.StatObject(context.Background(), s.bucket, s.key(name), minio.StatObjectOptions{})
+	if err != nil {
+		err = s3Error("stat", name, err)
+		if errors.Is(err, fs.ErrNotExist) && s.isPrefix(name) {
+			return &FileInfo{Name: path.Base(name), IsDir: true}, nil
+		}
+		return nil, err
+	}
+	modTime := info.LastModified
+	if sec, err := strconv.ParseInt(info.Metadata.Get("X-Amz-Meta-"+s3MtimeMeta), 10, 64); err == nil {
+		modTime = time.Unix(sec, 0)
+	}
+	return &FileInfo{
+		Name:    path.Base(name),
+		Size:    info.Size,
+		ModTime: modTime,
+	}, nil
+}
+
+// isPrefix reports whether any objects exist under name, which then acts as a
+// directory.
+func (s *s3Storage) isPrefix(name string) bool {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for obj := range s.client.ListObjects(ctx, s.bucket, minio.ListObjectsOptions{Prefix: s.key(name) + "/", MaxKeys: 1}) {
+		return obj.Err == nil
+	}
+	return false
+}
+
+func (s *s3Storage) Open(name string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(context.Background(), s.bucket, s.key(name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, s3Error("get", name, err)
+	}
+	if _, err := obj.Stat(); err != nil {
+		_ = obj.Close()
+		return nil, s3Error("get", name, err)
+	}
+	return obj, nil
+}
+
+// Create streams the contents into a multipart upload, which only becomes
+// visible once it is completed by Close.
+func (s *s3Storage) Create(name string, modTime time.Time) (Writer, error) {
+	opts := minio.PutObjectOptions{PartSize: s3PartSize}
+	if !modTime.IsZero() {
+		opts.UserMetadata = map[string]string{s3MtimeMeta: strconv.FormatInt(modTime.Unix(), 10)}
+	}
+	pr, pw := io.Pipe()
+	sw := &s3Writer{pw: pw, done: make(chan error, 1)}
+	go func() {
+		_, err := s.client.PutObject(context.Background(), s.bucket, s.key(name), pr, -1, opts)
+		_ = pr.CloseWithError(err)
+		sw.done <- err
+	}()
+	return sw, nil
+}
+
+func (s *s3Storage) Rename(oldName, newName string) error {
+	if err := s.Link(oldName, newName); err != nil {
+		return err
+	}
+	return s.Remove(oldName)
+}
+
+// List returns the objects and common prefixes directly under dir.
+func (s *s3Storage) List(dir string) ([]string, error) {
+	prefix := s.key(dir)
+	if prefix != "" {
+		prefix = prefix + "/"
+	}
+	var names []string
+	for obj := range s.client.ListObjects(context.Background(), s.bucket, minio.ListObjectsOptions{Prefix: prefix}) {
+		if obj.Err != nil {
+			return nil, s3Error("list", dir, obj.Err)
+		}
+		names = append(names, strings.TrimSuffix(strings.TrimPrefix(obj.Key, prefix), "/"))
+	}
+	return names, nil
+}
+
+// Link makes a server-side copy, as object stores have no links.
+func (s *s3Storage) Link(oldName, newName string) error {
+	_, err := s.client.CopyObject(context.Background(),
+		minio.CopyDestOptions{Bucket: s.bucket, Object: s.key(newName)},
+		minio.CopySrcOptions{Bucket: s.bucket, Object: s.key(oldName)})
+	return s3Error("copy", oldName, err)
+}
+
+func (s *s3Storage) Remove(name string) error {
+	return s3Error("remove", name, s.client.RemoveObject(context.Background(), s.bucket, s.key(name), minio.RemoveObjectOptions{}))
+}
+
+// MkdirAll is a no-op, as object stores have no directories.
+func (s *s3Storage) MkdirAll(string) error {
+	return nil
+}
+
+type s3Writer struct {
+	pw   *io.PipeWriter
+	done chan error
+}
+
+func (sw *s3Writer) Write(p []byte) (int, error) {
+	return sw.pw.Write(p)
+}
+
+func (sw *s3Writer) Close() error {
+	_ = sw.pw.Close()
+	return errors.Wrap(<-sw.done, "s3 upload")
+}
+
+func (sw *s3Writer) Abort() error {
+	_ = sw.pw.CloseWithError(errors.New("upload aborted"))
+	<-sw.done
+	return nil
+}
+
+func s3Error(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if minio.ToErrorResponse(err).StatusCode == http.StatusNotFound {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return errors.Wrapf(err, "s3 %v %v", op, name)
+}
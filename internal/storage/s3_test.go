@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johannesboyne/gofakes3"
+	"github.com/johannesboyne/gofakes3/backend/s3mem"
+)
+
+func TestS3(t *testing.T) {
+	backend := s3mem.New()
+	if err := backend.CreateBucket("photos"); err != nil {
+		t.Fatalf("create bucket: %v", err)
+	}
+	ts := httptest.NewTLSServer(gofakes3.New(backend).Server())
+	defer ts.Close()
+
+	s, err := NewS3("photos", "/backup/", S3Options{
+		Endpoint:  strings.TrimPrefix(ts.URL, "https://"),
+		AccessKey: "key",
+		SecretKey: "secret",
+		Transport: ts.Client().Transport,
+	})
+	if err != nil {
+		t.Fatalf("new s3: %v", err)
+	}
+	testStorage(t, s)
+}
+
+func TestS3BareBucket(t *testing.T) {
+	backend := s3mem.New()
+	if err := backend.CreateBucket("photos"); err != nil {
+		t.Fatalf("create bucket: %v", err)
+	}
+	ts := httptest.NewTLSServer(gofakes3.New(backend).Server())
+	defer ts.Close()
+
+	u, err := url.Parse("s3://photos")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewS3(u.Host, u.Path, S3Options{
+		Endpoint:  strings.TrimPrefix(ts.URL, "https://"),
+		AccessKey: "key",
+		SecretKey: "secret",
+		Transport: ts.Client().Transport,
+	})
+	if err != nil {
+		t.Fatalf("new s3: %v", err)
+	}
+	w, err := s.Create("2021/a.jpg", time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = w.Write([]byte("a"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var found []string
+	err = Walk(s, ".", func(name string, _ *FileInfo) error {
+		found = append(found, name)
+		return nil
+	})
+	if err != nil || len(found) != 1 || found[0] != "2021/a.jpg" {
+		t.Errorf("walk from the bucket root = %v, %v", found, err)
+	}
+	testStorage(t, s)
+}
+
+// TestS3MinIO runs against a real MinIO, e.g.:
+//
+//	GPHOTOBACKUP_TEST_S3_ENDPOINT=localhost:9000 GPHOTOBACKUP_TEST_S3_BUCKET=test \
+//	AWS_ACCESS_KEY_ID=minioadmin AWS_SECRET_ACCESS_KEY=minioadmin go test ./internal/storage
+func TestS3MinIO(t *testing.T) {
+	endpoint := os.Getenv("GPHOTOBACKUP_TEST_S3_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("GPHOTOBACKUP_TEST_S3_ENDPOINT not set")
+	}
+	s, err := NewS3(os.Getenv("GPHOTOBACKUP_TEST_S3_BUCKET"), t.Name(), S3Options{
+		Endpoint: endpoint,
+		Insecure: true,
+	})
+	if err != nil {
+		t.Fatalf("new s3: %v", err)
+	}
+	testStorage(t, s)
+}
@@ -0,0 +1,297 @@
+package storage
+
+import (
+	"io"
+	"io/fs"
+	"net"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+type SFTPOptions struct {
+	// KeyFiles are private keys to try, in addition to any keys held by the
+	// agent at $SSH_AUTH_SOCK.
+	KeyFiles []string
+
+	// KnownHostsFile is used to verify the server's host key, unless
+	// HostKeyCallback is set.
+	KnownHostsFile  string
+	HostKeyCallback ssh.HostKeyCallback
+
+	// Connections is the maximum number of connections kept open to the host.
+	Connections int
+}
+
+type sftpStorage struct {
+	addr   string
+	root   string
+	config *ssh.ClientConfig
+	pool   chan *sftpConn
+}
+
+type sftpConn struct {
+	ssh  *ssh.Client
+	sftp *sftp.Client
+}
+
+// NewSFTP returns a Storage that writes to root on the SSH server at addr.
+// Connections are dialed lazily and shared between callers.
+func NewSFTP(user, addr, root string, opts SFTPOptions) (Storage, error) {
+	var auths []ssh.AuthMethod
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			auths = append(auths, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	var signers []ssh.Signer
+	for _, keyFile := range opts.KeyFiles {
+		key, err := os.ReadFile(keyFile)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "reading key %v", keyFile)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing key %v", keyFile)
+		}
+		signers = append(signers, signer)
+	}
+	if len(signers) > 0 {
+		auths = append(auths, ssh.PublicKeys(signers...))
+	}
+	if len(auths) == 0 {
+		return nil, errors.New("no SSH agent or keys available")
+	}
+
+	hostKeyCallback := opts.HostKeyCallback
+	if hostKeyCallback == nil {
+		cb, err := knownhosts.New(opts.KnownHostsFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading known hosts %v", opts.KnownHostsFile)
+		}
+		hostKeyCallback = cb
+	}
+
+	if opts.Connections <= 0 {
+		opts.Connections = 4
+	}
+	pool := make(chan *sftpConn, opts.Connections)
+	for i := 0; i < opts.Connections; i++ {
+		pool <- nil
+	}
+
+	return &sftpStorage{
+		addr: addr,
+		root: root,
+		config: &ssh.ClientConfig{
+			User:            user,
+			Auth:            auths,
+			HostKeyCallback: hostKeyCallback,
+			Timeout:         30 * time.Second,
+		},
+		pool: pool,
+	}, nil
+}
+
+func (s *sftpStorage) path(name string) string {
+	return path.Join(s.root, name)
+}
+
+// get borrows a connection from the pool, dialing a new one if needed.
+func (s *sftpStorage) get() (*sftpConn, error) {
+	conn := <-s.pool
+	if conn != nil {
+		return conn, nil
+	}
+	sshClient, err := ssh.Dial("tcp", s.addr, s.config)
+	if err != nil {
+		s.pool <- nil
+		return nil, errors.Wrapf(err, "dialing %v", s.addr)
+	}
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		_ = sshClient.Close()
+		s.pool <- nil
+		return nil, errors.Wrap(err, "starting sftp")
+	}
+	return &sftpConn{ssh: sshClient, sftp: sftpClient}, nil
+}
+
+// put returns a connection to the pool, or closes it if err shows it's broken.
+func (s *sftpStorage) put(conn *sftpConn, err error) {
+	var statusErr *sftp.StatusError
+	if err != nil && !errors.Is(err, fs.ErrNotExist) && !errors.Is(err, fs.ErrExist) &&
+		!errors.Is(err, fs.ErrPermission) && !errors.As(err, &statusErr) {
+		_ = conn.sftp.Close()
+		_ = conn.ssh.Close()
+		conn = nil
+	}
+	s.pool <- conn
+}
+
+func (s *sftpStorage) do(fn func(c *sftp.Client) error) error {
+	conn, err := s.get()
+	if err != nil {
+		return err
+	}
+	err = fn(conn.sftp)
+	s.put(conn, err)
+	return err
+}
+
+func (s *sftpStorage) Stat(name string) (*FileInfo, error) {
+	var fi os.FileInfo
+	err := s.do(func(c *sftp.Client) (err error) {
+		fi, err = c.Stat(s.path(name))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &FileInfo{
+		Name:    fi.Name(),
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		IsDir:   fi.IsDir(),
+	}, nil
+}
+
+// Open holds a connection until the returned stream is closed.
+func (s *sftpStorage) Open(name string) (io.ReadCloser, error) {
+	conn, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	f, err := conn.sftp.Open(s.path(name))
+	if err != nil {
+		s.put(conn, err)
+		return nil, err
+	}
+	return &sftpReader{File: f, s: s, conn: conn}, nil
+}
+
+// Create writes to a temporary file that is renamed into place by Close. The
+// connection is held until then.
+func (s *sftpStorage) Create(name string, modTime time.Time) (Writer, error) {
+	conn, err := s.get()
+	if err != nil {
+		return nil, err
+	}
+	dest := s.path(name)
+	tmp := partialName(dest)
+	f, err := conn.sftp.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		s.put(conn, err)
+		return nil, errors.Wrapf(err, "creating temp file for %v", name)
+	}
+	return &sftpWriter{s: s, conn: conn, f: f, tmp: tmp, dest: dest, modTime: modTime}, nil
+}
+
+func (s *sftpStorage) Rename(oldName, newName string) error {
+	return s.do(func(c *sftp.Client) error {
+		return rename(c, s.path(oldName), s.path(newName))
+	})
+}
+
+func (s *sftpStorage) List(dir string) ([]string, error) {
+	var names []string
+	err := s.do(func(c *sftp.Client) error {
+		fis, err := c.ReadDir(s.path(dir))
+		for _, fi := range fis {
+			names = append(names, fi.Name())
+		}
+		return err
+	})
+	return filterPartial(names), err
+}
+
+func (s *sftpStorage) Link(oldName, newName string) error {
+	return s.do(func(c *sftp.Client) error {
+		return c.Link(s.path(oldName), s.path(newName))
+	})
+}
+
+func (s *sftpStorage) Remove(name string) error {
+	return s.do(func(c *sftp.Client) error {
+		return c.Remove(s.path(name))
+	})
+}
+
+func (s *sftpStorage) MkdirAll(dir string) error {
+	return s.do(func(c *sftp.Client) error {
+		return c.MkdirAll(s.path(dir))
+	})
+}
+
+// rename atomically replaces newName where the server supports it.
+func rename(c *sftp.Client, oldName, newName string) error {
+	if _, ok := c.HasExtension("posix-rename@openssh.com"); ok {
+		return c.PosixRename(oldName, newName)
+	}
+	_ = c.Remove(newName)
+	return c.Rename(oldName, newName)
+}
+
+type sftpReader struct {
+	*sftp.File
+	s    *sftpStorage
+	conn *sftpConn
+}
+
+func (sr *sftpReader) Close() error {
+	err := sr.File.Close()
+	sr.s.put(sr.conn, err)
+	return err
+}
+
+type sftpWriter struct {
+	s       *sftpStorage
+	conn    *sftpConn
+	f       *sftp.File
+	tmp     string
+	dest    string
+	modTime time.Time
+}
+
+func (sw *sftpWriter) Write(p []byte) (int, error) {
+	return sw.f.Write(p)
+}
+
+func (sw *sftpWriter) Close() error {
+	err := sw.commit()
+	if err != nil {
+		_ = sw.conn.sftp.Remove(sw.tmp)
+	}
+	sw.s.put(sw.conn, err)
+	return err
+}
+
+func (sw *sftpWriter) commit() error {
+	if err := sw.f.Close(); err != nil {
+		return err
+	}
+	if err := sw.conn.sftp.Chmod(sw.tmp, 0644); err != nil {
+		return errors.Wrap(err, "chmod")
+	}
+	if !sw.modTime.IsZero() {
+		if err := sw.conn.sftp.Chtimes(sw.tmp, sw.modTime, sw.modTime); err != nil {
+			return errors.Wrap(err, "error changing times")
+		}
+	}
+	return errors.Wrapf(rename(sw.conn.sftp, sw.tmp, sw.dest), "renaming to %v", sw.dest)
+}
+
+func (sw *sftpWriter) Abort() error {
+	_ = sw.f.Close()
+	err := sw.conn.sftp.Remove(sw.tmp)
+	sw.s.put(sw.conn, err)
+	return err
+}
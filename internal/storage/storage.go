@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+const partialSuffix = ".partial"
+
+// Storage is a backup destination. Names are slash separated and relative to
+// the root of the destination.
+type Storage interface {
+	// Stat returns details of the named file, or an error satisfying
+	// errors.Is(err, fs.ErrNotExist) if it does not exist.
+	Stat(name string) (*FileInfo, error)
+
+	// Open returns a stream of the named file's contents.
+	Open(name string) (io.ReadCloser, error)
+
+	// Create opens a stream to a new file. The contents only become visible
+	// under name once the returned Writer is closed successfully. modTime is
+	// recorded as the file's modification time if it is non-zero.
+	Create(name string, modTime time.Time) (Writer, error)
+
+	// Rename moves oldName to newName, replacing newName if it exists.
+	Rename(oldName, newName string) error
+
+	// List returns the names of the entries in dir, excluding any temporary
+	// files left by writers that were interrupted.
+	List(dir string) ([]string, error)
+
+	// Link makes newName refer to the same contents as oldName. See
+	// HardLinks for whether they share storage.
+	Link(oldName, newName string) error
+
+	// Remove deletes the named file.
+	Remove(name string) error
+
+	// MkdirAll creates dir and any parents that don't exist yet.
+	MkdirAll(dir string) error
+}
+
+// Writer is a stream to a file being created.
+type Writer interface {
+	io.Writer
+
+	// Close commits the written data under the file's final name.
+	Close() error
+
+	// Abort discards the written data.
+	Abort() error
+}
+
+// partialName returns the temporary name a Writer uses for name before it is
+// committed.
+func partialName(name string) string {
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+	return path.Join(path.Dir(name), "."+path.Base(name)+"."+hex.EncodeToString(suffix)+partialSuffix)
+}
+
+func filterPartial(names []string) []string {
+	filtered := names[:0]
+	for _, name := range names {
+		if !strings.HasSuffix(name, partialSuffix) {
+			filtered = append(filtered, name)
+		}
+	}
+	return filtered
+}
+
+type FileInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+}
+
+// Open returns the Storage for the --out destination dest, which is either a
+// local directory or a URL such as s3://bucket/prefix, sftp://user@host/path
+// or webdavs://host/path.
+func Open(dest string) (Storage, error) {
+	if !strings.Contains(dest, "://") {
+		return NewLocal(dest), nil
+	}
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing destination %v", dest)
+	}
+	switch u.Scheme {
+	case "s3":
+		return NewS3(u.Host, u.Path, S3Options{
+			Endpoint:  viper.GetString("s3.endpoint"),
+			Region:    viper.GetString("s3.region"),
+			AccessKey: viper.GetString("s3.accessKey"),
+			SecretKey: viper.GetString("s3.secretKey"),
+			Insecure:  viper.GetBool("s3.insecure"),
+		})
+	case "sftp":
+		return openSFTP(u)
+	case "webdav", "webdavs", "http", "https":
+		switch u.Scheme {
+		case "webdav":
+			u.Scheme = "http"
+		case "webdavs":
+			u.Scheme = "https"
+		}
+		return NewWebDAV(u.String(), WebDAVOptions{
+			User:     viper.GetString("webdav.user"),
+			Password: viper.GetString("webdav.password"),
+		})
+	default:
+		return nil, errors.Errorf("unsupported destination scheme %q", u.Scheme)
+	}
+}
+
+func openSFTP(u *url.URL) (Storage, error) {
+	home, _ := os.UserHomeDir()
+	user := u.User.Username()
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+	addr := u.Host
+	if u.Port() == "" {
+		addr = net.JoinHostPort(u.Hostname(), "22")
+	}
+
+	keyFiles := []string{
+		filepath.Join(home, ".ssh", "id_ed25519"),
+		filepath.Join(home, ".ssh", "id_ecdsa"),
+		filepath.Join(home, ".ssh", "id_rsa"),
+	}
+	if keyFile := viper.GetString("sftp.keyFile"); keyFile != "" {
+		keyFiles = []string{keyFile}
+	}
+	knownHosts := viper.GetString("sftp.knownHosts")
+	if knownHosts == "" {
+		knownHosts = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	connections := viper.GetInt("sftp.connections")
+	if connections == 0 {
+		connections = viper.GetInt("workers")
+		if viper.GetString("workers") == "auto" {
+			connections = viper.GetInt("workers-max")
+		}
+	}
+
+	return NewSFTP(user, addr, u.Path, SFTPOptions{
+		KeyFiles:       keyFiles,
+		KnownHostsFile: knownHosts,
+		Connections:    connections,
+	})
+}
+
+// HardLinks reports whether Link makes linked names share one copy of the
+// data. Object stores and WebDAV have no links, so there Link copies.
+func HardLinks(s Storage) bool {
+	switch s := s.(type) {
+	case *local, *sftpStorage, *Memory:
+		return true
+	case *encrypted:
+		return HardLinks(s.Storage)
+	}
+	return false
+}
+
+// Walk calls fn for every file under dir, recursively.
+func Walk(s Storage, dir string, fn func(name string, fi *FileInfo) error) error {
+	names, err := s.List(dir)
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		name := path.Join(dir, n)
+		fi, err := s.Stat(name)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir {
+			err = Walk(s, name, fn)
+		} else {
+			err = fn(name, fi)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
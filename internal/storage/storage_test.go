@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestLocal(t *testing.T) {
+	testStorage(t, NewLocal(t.TempDir()))
+}
+
+func TestMemory(t *testing.T) {
+	testStorage(t, NewMemory())
+}
+
+// testStorage exercises the behavior every Storage implementation must share.
+func testStorage(t *testing.T, s Storage) {
+	t.Helper()
+	modTime := time.Date(2021, 5, 4, 3, 2, 1, 0, time.UTC)
+
+	if _, err := s.Stat("2021/05/04/a.jpg"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected not exist, got: %v", err)
+	}
+	if err := s.MkdirAll("2021/05/04"); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+
+	w, err := s.Create("2021/05/04/a.jpg", modTime)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if _, err := s.Stat("2021/05/04/a.jpg"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected file to be invisible before close, got: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	fi, err := s.Stat("2021/05/04/a.jpg")
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if fi.Size != 5 || !fi.ModTime.Equal(modTime) || fi.IsDir {
+		t.Fatalf("unexpected file info: %+v", fi)
+	}
+	if got := readAll(t, s, "2021/05/04/a.jpg"); got != "hello" {
+		t.Fatalf("expected: hello, got: %v", got)
+	}
+
+	w, err = s.Create("2021/05/04/b.jpg", modTime)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	_, _ = w.Write([]byte("partial"))
+	if err := w.Abort(); err != nil {
+		t.Fatalf("abort: %v", err)
+	}
+	if _, err := s.Stat("2021/05/04/b.jpg"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected aborted file to not exist, got: %v", err)
+	}
+
+	if err := s.Link("2021/05/04/a.jpg", "2021/05/04/c.jpg"); err != nil {
+		t.Fatalf("link: %v", err)
+	}
+	if err := s.Rename("2021/05/04/c.jpg", "2021/05/04/d.jpg"); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	got, err := s.List("2021/05/04")
+	if err != nil {
+		t.Fatalf("list: %v", err)
+	}
+	sort.Strings(got)
+	if want := []string{"a.jpg", "d.jpg"}; !reflect.DeepEqual(want, got) {
+		t.Fatalf("expected: %v, got: %v", want, got)
+	}
+
+	if err := s.Remove("2021/05/04/d.jpg"); err != nil {
+		t.Fatalf("remove: %v", err)
+	}
+	if _, err := s.Stat("2021/05/04/d.jpg"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected removed file to not exist, got: %v", err)
+	}
+}
+
+func readAll(t *testing.T, s Storage, name string) string {
+	t.Helper()
+	r, err := s.Open(name)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return string(b)
+}
+
+func TestHardLinks(t *testing.T) {
+	if !HardLinks(NewLocal(t.TempDir())) || !HardLinks(NewEncrypted(NewMemory(), nil, nil)) {
+		t.Error("expected local and encrypted memory storage to hard link")
+	}
+	s3, err := NewS3("bucket", "", S3Options{Endpoint: "127.0.0.1:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if HardLinks(s3) {
+		t.Error("expected S3 links to be copies")
+	}
+}
@@ -0,0 +1,300 @@
+package storage
+
+import (
+	"encoding/xml"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:"><d:prop><d:resourcetype/><d:getcontentlength/><d:getlastmodified/></d:prop></d:propfind>`
+
+type WebDAVOptions struct {
+	User     string
+	Password string
+	Client   *http.Client
+}
+
+type webdavStorage struct {
+	base   *url.URL
+	opts   WebDAVOptions
+	client *http.Client
+
+	// dirs caches the collections known to exist, to save a round trip per
+	// MkdirAll call.
+	dirs sync.Map
+}
+
+// NewWebDAV returns a Storage that writes to the WebDAV collection at baseURL,
+// e.g. https://cloud.example.com/remote.php/dav/files/me/Photos.
+func NewWebDAV(baseURL string, opts WebDAVOptions) (Storage, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing webdav url")
+	}
+	if u.User != nil {
+		opts.User = u.User.Username()
+		if pw, ok := u.User.Password(); ok {
+			opts.Password = pw
+		}
+		u.User = nil
+	}
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &webdavStorage{base: u, opts: opts, client: client}, nil
+}
+
+func (s *webdavStorage) url(name string) string {
+	u := *s.base
+	u.Path = path.Join(s.base.Path, name)
+	return u.String()
+}
+
+func (s *webdavStorage) do(method, name string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequest(method, s.url(name), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if s.opts.User != "" {
+		req.SetBasicAuth(s.opts.User, s.opts.Password)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "webdav %v %v", method, name)
+	}
+	return resp, nil
+}
+
+// expect performs a request and discards the response, returning an error
+// unless it has one of the codes given.
+func (s *webdavStorage) expect(method, name string, header http.Header, codes ...int) error {
+	resp, err := s.do(method, name, nil, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return webdavError(method, name, resp.StatusCode, codes...)
+}
+
+func (s *webdavStorage) propfind(name, depth string) (*davMultistatus, error) {
+	resp, err := s.do("PROPFIND", name, strings.NewReader(propfindBody), http.Header{
+		"Depth":        {depth},
+		"Content-Type": {"application/xml; charset=utf-8"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err := webdavError("PROPFIND", name, resp.StatusCode, http.StatusMultiStatus); err != nil {
+		return nil, err
+	}
+	ms := &davMultistatus{}
+	if err := xml.NewDecoder(resp.Body).Decode(ms); err != nil {
+		return nil, errors.Wrapf(err, "decoding PROPFIND response for %v", name)
+	}
+	return ms, nil
+}
+
+func (s *webdavStorage) Stat(name string) (*FileInfo, error) {
+	ms, err := s.propfind(name, "0")
+	if err != nil {
+		return nil, err
+	}
+	if len(ms.Responses) == 0 {
+		return nil, &fs.PathError{Op: "PROPFIND", Path: name, Err: fs.ErrNotExist}
+	}
+	fi := ms.Responses[0].fileInfo()
+	fi.Name = path.Base(name)
+	return fi, nil
+}
+
+func (s *webdavStorage) Open(name string) (io.ReadCloser, error) {
+	resp, err := s.do(http.MethodGet, name, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := webdavError(http.MethodGet, name, resp.StatusCode, http.StatusOK); err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Create streams a PUT to a temporary name, which is moved into place by
+// Close. The modification time is sent as X-OC-Mtime, which Nextcloud and
+// ownCloud honor.
+func (s *webdavStorage) Create(name string, modTime time.Time) (Writer, error) {
+	tmp := partialName(name)
+	header := http.Header{}
+	if !modTime.IsZero() {
+		header.Set("X-OC-Mtime", strconv.FormatInt(modTime.Unix(), 10))
+	}
+	pr, pw := io.Pipe()
+	ww := &webdavWriter{s: s, pw: pw, tmp: tmp, dest: name, done: make(chan error, 1)}
+	go func() {
+		resp, err := s.do(http.MethodPut, tmp, pr, header)
+		if err == nil {
+			_, _ = io.Copy(io.Discard, resp.Body)
+			_ = resp.Body.Close()
+			err = webdavError(http.MethodPut, tmp, resp.StatusCode, http.StatusCreated, http.StatusNoContent, http.StatusOK)
+		}
+		_ = pr.CloseWithError(err)
+		ww.done <- err
+	}()
+	return ww, nil
+}
+
+func (s *webdavStorage) Rename(oldName, newName string) error {
+	return s.expect("MOVE", oldName, http.Header{
+		"Destination": {s.url(newName)},
+		"Overwrite":   {"T"},
+	}, http.StatusCreated, http.StatusNoContent)
+}
+
+func (s *webdavStorage) List(dir string) ([]string, error) {
+	ms, err := s.propfind(dir, "1")
+	if err != nil {
+		return nil, err
+	}
+	self := path.Clean(path.Join(s.base.Path, dir))
+	var names []string
+	for _, r := range ms.Responses {
+		p := r.Href
+		if u, err := url.Parse(r.Href); err == nil {
+			p = u.Path
+		}
+		if path.Clean(p) == self {
+			continue
+		}
+		names = append(names, path.Base(p))
+	}
+	return filterPartial(names), nil
+}
+
+// Link makes a server-side copy, as WebDAV has no links.
+func (s *webdavStorage) Link(oldName, newName string) error {
+	return s.expect("COPY", oldName, http.Header{
+		"Destination": {s.url(newName)},
+		"Overwrite":   {"F"},
+	}, http.StatusCreated, http.StatusNoContent)
+}
+
+func (s *webdavStorage) Remove(name string) error {
+	return s.expect(http.MethodDelete, name, nil, http.StatusNoContent, http.StatusOK)
+}
+
+// MkdirAll creates each missing collection with MKCOL, starting at the root.
+func (s *webdavStorage) MkdirAll(dir string) error {
+	dir = path.Clean(dir)
+	if dir == "." {
+		return nil
+	}
+	if _, ok := s.dirs.Load(dir); ok {
+		return nil
+	}
+	if err := s.MkdirAll(path.Dir(dir)); err != nil {
+		return err
+	}
+	// 405 means the collection already exists.
+	if err := s.expect("MKCOL", dir, nil, http.StatusCreated, http.StatusMethodNotAllowed); err != nil {
+		return err
+	}
+	s.dirs.Store(dir, true)
+	return nil
+}
+
+type webdavWriter struct {
+	s    *webdavStorage
+	pw   *io.PipeWriter
+	tmp  string
+	dest string
+	done chan error
+}
+
+func (ww *webdavWriter) Write(p []byte) (int, error) {
+	return ww.pw.Write(p)
+}
+
+func (ww *webdavWriter) Close() error {
+	_ = ww.pw.Close()
+	if err := <-ww.done; err != nil {
+		_ = ww.s.Remove(ww.tmp)
+		return err
+	}
+	if err := ww.s.Rename(ww.tmp, ww.dest); err != nil {
+		_ = ww.s.Remove(ww.tmp)
+		return errors.Wrapf(err, "renaming to %v", ww.dest)
+	}
+	return nil
+}
+
+func (ww *webdavWriter) Abort() error {
+	_ = ww.pw.CloseWithError(errors.New("upload aborted"))
+	<-ww.done
+	if err := ww.s.Remove(ww.tmp); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+type davMultistatus struct {
+	Responses []davResponse `xml:"response"`
+}
+
+type davResponse struct {
+	Href     string `xml:"href"`
+	Propstat []struct {
+		Prop struct {
+			ResourceType struct {
+				Collection *struct{} `xml:"collection"`
+			} `xml:"resourcetype"`
+			ContentLength int64  `xml:"getcontentlength"`
+			LastModified  string `xml:"getlastmodified"`
+		} `xml:"prop"`
+		Status string `xml:"status"`
+	} `xml:"propstat"`
+}
+
+func (r *davResponse) fileInfo() *FileInfo {
+	fi := &FileInfo{}
+	for _, ps := range r.Propstat {
+		if !strings.Contains(ps.Status, " 200 ") {
+			continue
+		}
+		fi.IsDir = fi.IsDir || ps.Prop.ResourceType.Collection != nil
+		if ps.Prop.ContentLength != 0 {
+			fi.Size = ps.Prop.ContentLength
+		}
+		if t, err := http.ParseTime(ps.Prop.LastModified); err == nil {
+			fi.ModTime = t
+		}
+	}
+	return fi
+}
+
+func webdavError(method, name string, code int, expected ...int) error {
+	for _, c := range expected {
+		if code == c {
+			return nil
+		}
+	}
+	if code == http.StatusNotFound {
+		return &fs.PathError{Op: method, Path: name, Err: fs.ErrNotExist}
+	}
+	return errors.Errorf("webdav %v %v: unexpected status %v", method, name, code)
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+func TestWebDAV(t *testing.T) {
+	dir := t.TempDir()
+	dav := &webdav.Handler{
+		Prefix:     "/remote.php/dav/files/me",
+		FileSystem: webdav.Dir(dir),
+		LockSystem: webdav.NewMemLS(),
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, _ := r.BasicAuth(); user != "me" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		dav.ServeHTTP(w, r)
+		// Emulate Nextcloud, which sets the modification time from X-OC-Mtime.
+		if sec, err := strconv.ParseInt(r.Header.Get("X-OC-Mtime"), 10, 64); err == nil && r.Method == http.MethodPut {
+			fp := filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(r.URL.Path, dav.Prefix)))
+			_ = os.Chtimes(fp, time.Unix(sec, 0), time.Unix(sec, 0))
+		}
+	}))
+	defer ts.Close()
+
+	s, err := NewWebDAV(strings.Replace(ts.URL, "http://", "http://me:secret@", 1)+"/remote.php/dav/files/me", WebDAVOptions{})
+	if err != nil {
+		t.Fatalf("new webdav: %v", err)
+	}
+	testStorage(t, s)
+}
@@ -0,0 +1,296 @@
+package takeout
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gphotosuploader/googlemirror/api/photoslibrary/v1"
+	"github.com/pkg/errors"
+	"github.com/ttomsu/gphotobackup/internal/backup"
+	"github.com/ttomsu/gphotobackup/internal/storage"
+	"go.uber.org/zap"
+)
+
+// duplicateSuffix matches the "(1)" Takeout adds to the second of two files
+// with the same name, e.g. IMG_0001(1).JPG.
+var duplicateSuffix = regexp.MustCompile(`\(\d+\)(\.[^.]*)?$`)
+
+// SearchFunc returns the media items created around day.
+type SearchFunc func(day time.Time) ([]*photoslibrary.MediaItem, error)
+
+// Importer places files from Google Takeout archives into the backup tree,
+// named as if they had been downloaded by backup.
+type Importer struct {
+	Store  storage.Storage
+	Search SearchFunc
+	Logger *zap.SugaredLogger
+	// Obfuscate names items by their ID as --obfuscate-names does, and
+	// Sidecars saves their metadata next to them as --sidecars does.
+	Obfuscate bool
+	Sidecars  bool
+
+	// byDay caches search results, keyed by day.
+	byDay map[string][]*photoslibrary.MediaItem
+}
+
+type Result struct {
+	Imported  int
+	Existing  int
+	Unmatched []string
+}
+
+// metadata is the subset of a Takeout .json file used for matching.
+type metadata struct {
+	Title          string `json:"title"`
+	PhotoTakenTime struct {
+		Timestamp string `json:"timestamp"`
+	} `json:"photoTakenTime"`
+}
+
+// Import reads the .zip, .tgz or .tar.gz Takeout archives. The parts of a
+// multi-part Takeout must be imported together, as an item's .json metadata
+// may be in a different part than its media.
+func (im *Importer) Import(archivePaths []string, result *Result) error {
+	if im.byDay == nil {
+		im.byDay = make(map[string][]*photoslibrary.MediaItem)
+	}
+
+	// The .json metadata may come before or after the media it describes, so
+	// read all of it first.
+	metas := &metaIndex{byFile: map[string]*metadata{}, byTitle: map[string]*metadata{}}
+	for _, archivePath := range archivePaths {
+		err := walkArchive(archivePath, func(name string, r io.Reader) error {
+			if strings.HasSuffix(name, ".json") {
+				metas.add(name, r)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, archivePath := range archivePaths {
+		if err := walkArchive(archivePath, func(name string, r io.Reader) error {
+			return im.place(metas, name, r, result)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// place writes the media file name to the backup tree.
+func (im *Importer) place(metas *metaIndex, name string, r io.Reader, result *Result) error {
+	if strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".html") {
+		return nil
+	}
+	m, suffix := metas.find(name)
+	if m == nil {
+		result.Unmatched = append(result.Unmatched, name)
+		return nil
+	}
+	item, err := im.match(m)
+	if err != nil {
+		return err
+	} else if item == nil {
+		result.Unmatched = append(result.Unmatched, name)
+		return nil
+	}
+
+	// Edited and duplicate copies described by the original's metadata sit
+	// next to it rather than replacing it.
+	dest := backup.DestPath(item)
+	if im.Obfuscate {
+		dest = backup.ObfuscatedDestPath(item)
+	}
+	dest = withSuffix(dest, suffix)
+	if _, err := im.Store.Stat(dest); err == nil {
+		result.Existing++
+		return nil
+	}
+	if err := im.write(dest, item, r); err != nil {
+		return errors.Wrapf(err, "importing %v", name)
+	}
+	// A copy gets no sidecar, as restoring its name would replace the
+	// original.
+	if (im.Obfuscate || im.Sidecars) && suffix == "" {
+		if err := backup.WriteSidecar(im.Store, item, dest); err != nil {
+			return errors.Wrapf(err, "importing %v", name)
+		}
+	}
+	im.Logger.Debugf("Imported %v to %v", name, dest)
+	result.Imported++
+	return nil
+}
+
+// metaIndex holds Takeout metadata by the media file each .json is named
+// after, and by the title inside it for names Takeout truncated.
+type metaIndex struct {
+	byFile  map[string]*metadata
+	byTitle map[string]*metadata
+}
+
+// jsonDuplicate matches the name of the metadata for a duplicate, e.g.
+// IMG_0001.JPG(1) for IMG_0001(1).JPG.
+var jsonDuplicate = regexp.MustCompile(`^(.*)(\.[^./]*)(\(\d+\))$`)
+
+func (mi *metaIndex) add(name string, r io.Reader) {
+	m := &metadata{}
+	if err := json.NewDecoder(r).Decode(m); err != nil || m.Title == "" || m.PhotoTakenTime.Timestamp == "" {
+		return
+	}
+	media := strings.TrimSuffix(strings.TrimSuffix(name, ".json"), ".supplemental-metadata")
+	if parts := jsonDuplicate.FindStringSubmatch(media); parts != nil {
+		media = parts[1] + parts[3] + parts[2]
+	}
+	mi.byFile[media] = m
+	if key := path.Join(path.Dir(name), m.Title); mi.byTitle[key] == nil {
+		mi.byTitle[key] = m
+	}
+}
+
+func (mi *metaIndex) get(name string) *metadata {
+	if m, ok := mi.byFile[name]; ok {
+		return m
+	}
+	return mi.byTitle[name]
+}
+
+// find returns the metadata for the media file name. Takeout gives edited
+// copies (IMG-edited.JPG) and some duplicates (IMG(1).JPG) no metadata of
+// their own, so those use the original's, and suffix is what tells them
+// apart from it.
+func (mi *metaIndex) find(name string) (m *metadata, suffix string) {
+	if m := mi.get(name); m != nil {
+		return m, ""
+	}
+	dir, base := path.Split(name)
+	ext := path.Ext(base)
+	if stem := strings.TrimSuffix(base, ext); strings.HasSuffix(stem, "-edited") {
+		if m := mi.get(path.Join(dir, strings.TrimSuffix(stem, "-edited")+ext)); m != nil {
+			return m, "-edited"
+		}
+	}
+	if dup := duplicateSuffix.FindString(base); dup != "" {
+		if m := mi.get(path.Join(dir, duplicateSuffix.ReplaceAllString(base, "$1"))); m != nil {
+			return m, strings.TrimSuffix(dup, ext)
+		}
+	}
+	return nil, ""
+}
+
+// withSuffix adds suffix to the name in p, before its extension.
+func withSuffix(p, suffix string) string {
+	ext := path.Ext(p)
+	return strings.TrimSuffix(p, ext) + suffix + ext
+}
+
+// match finds the media item with the same filename and creation time.
+func (im *Importer) match(m *metadata) (*photoslibrary.MediaItem, error) {
+	sec, err := strconv.ParseInt(m.PhotoTakenTime.Timestamp, 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+	taken := time.Unix(sec, 0).UTC()
+	day := taken.Format("2006-01-02")
+	items, ok := im.byDay[day]
+	if !ok {
+		if items, err = im.Search(taken); err != nil {
+			return nil, errors.Wrapf(err, "searching %v", day)
+		}
+		im.byDay[day] = items
+	}
+
+	var byName []*photoslibrary.MediaItem
+	for _, item := range items {
+		if item.Filename != m.Title {
+			continue
+		}
+		byName = append(byName, item)
+		created, err := time.Parse(time.RFC3339, item.MediaMetadata.CreationTime)
+		if err == nil && created.Unix() == sec {
+			return item, nil
+		}
+	}
+	if len(byName) == 1 {
+		return byName[0], nil
+	}
+	return nil, nil
+}
+
+func (im *Importer) write(dest string, item *photoslibrary.MediaItem, r io.Reader) error {
+	if err := im.Store.MkdirAll(path.Dir(dest)); err != nil {
+		return err
+	}
+	created, _ := time.Parse(time.RFC3339, item.MediaMetadata.CreationTime)
+	w, err := im.Store.Create(dest, created)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		_ = w.Abort()
+		return err
+	}
+	return w.Close()
+}
+
+// walkArchive calls fn for each regular file in a zip or gzipped tar archive.
+func walkArchive(archivePath string, fn func(name string, r io.Reader) error) error {
+	if strings.HasSuffix(archivePath, ".zip") {
+		zr, err := zip.OpenReader(archivePath)
+		if err != nil {
+			return errors.Wrapf(err, "opening %v", archivePath)
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			if f.FileInfo().IsDir() {
+				continue
+			}
+			r, err := f.Open()
+			if err != nil {
+				return errors.Wrapf(err, "reading %v", f.Name)
+			}
+			err = fn(f.Name, r)
+			_ = r.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return errors.Wrapf(err, "opening %v", archivePath)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return errors.Wrapf(err, "reading %v", archivePath)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrapf(err, "reading %v", archivePath)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package takeout
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gphotosuploader/googlemirror/api/photoslibrary/v1"
+	"github.com/ttomsu/gphotobackup/internal/backup"
+	"github.com/ttomsu/gphotobackup/internal/storage"
+	"go.uber.org/zap"
+)
+
+const dir = "Takeout/Google Photos/Photos from 2021/"
+
+var (
+	taken = time.Date(2021, 5, 4, 3, 2, 1, 0, time.UTC)
+	files = []struct{ name, data string }{
+		{dir + "IMG_1.JPG", "one"},
+		{dir + "IMG_1.JPG.json", metadataJSON("IMG_1.JPG", taken)},
+		{dir + "IMG_2.JPG.supplemental-metadata.json", metadataJSON("IMG_2.JPG", taken.Add(time.Minute))},
+		{dir + "IMG_2.JPG", "two"},
+		{dir + "IMG_3.JPG", "not in the library"},
+		{dir + "IMG_3.JPG.json", metadataJSON("IMG_3.JPG", taken)},
+		{dir + "no-metadata.jpg", "unknown"},
+		{dir + "metadata.json", `{"title": "Photos from 2021"}`},
+	}
+	items = []*photoslibrary.MediaItem{
+		mediaItem("id1", "IMG_1.JPG", taken),
+		mediaItem("id2", "IMG_2.JPG", taken.Add(time.Minute)),
+		mediaItem("id4", "IMG_4.JPG", taken),
+	}
+)
+
+func TestImport(t *testing.T) {
+	for _, archivePath := range []string{writeZip(t), writeTgz(t)} {
+		t.Run(filepath.Ext(archivePath), func(t *testing.T) {
+			store := storage.NewMemory()
+			searches := 0
+			im := &Importer{
+				Store: store,
+				Search: func(time.Time) ([]*photoslibrary.MediaItem, error) {
+					searches++
+					return items, nil
+				},
+				Logger: zap.NewNop().Sugar(),
+			}
+			result := &Result{}
+			if err := im.Import([]string{archivePath}, result); err != nil {
+				t.Fatalf("import: %v", err)
+			}
+
+			if result.Imported != 2 || result.Existing != 0 {
+				t.Fatalf("unexpected result: %+v", result)
+			}
+			if want := []string{dir + "IMG_3.JPG", dir + "no-metadata.jpg"}; !reflect.DeepEqual(want, result.Unmatched) {
+				t.Fatalf("expected unmatched: %v, got: %v", want, result.Unmatched)
+			}
+			if searches != 1 {
+				t.Fatalf("expected search results to be cached, got %v searches", searches)
+			}
+			for i, want := range []string{"one", "two"} {
+				got, err := store.ReadFile(backup.DestPath(items[i]))
+				if err != nil || string(got) != want {
+					t.Fatalf("expected %v to hold %q, got: %q, %v", backup.DestPath(items[i]), want, got, err)
+				}
+			}
+
+			result = &Result{}
+			if err := im.Import([]string{archivePath}, result); err != nil {
+				t.Fatalf("reimport: %v", err)
+			}
+			if result.Imported != 0 || result.Existing != 2 {
+				t.Fatalf("expected existing files to be skipped, got: %+v", result)
+			}
+		})
+	}
+}
+
+func TestImportMultiPartWithCopies(t *testing.T) {
+	part1 := []struct{ name, data string }{
+		{dir + "IMG_5-edited.JPG", "five edited"},
+		{dir + "IMG_5.JPG", "five"},
+		{dir + "IMG_6(1).JPG", "six again"},
+		{dir + "IMG_7(1).JPG", "seven b"},
+		{dir + "IMG_7.JPG", "seven"},
+	}
+	part2 := []struct{ name, data string }{
+		{dir + "IMG_5.JPG.json", metadataJSON("IMG_5.JPG", taken)},
+		{dir + "IMG_6.JPG", "six"},
+		{dir + "IMG_6.JPG.json", metadataJSON("IMG_6.JPG", taken)},
+		{dir + "IMG_7.JPG.json", metadataJSON("IMG_7.JPG", taken)},
+		{dir + "IMG_7.JPG(1).json", metadataJSON("IMG_7.JPG", taken.Add(2*time.Minute))},
+	}
+	library := []*photoslibrary.MediaItem{
+		mediaItem("id5", "IMG_5.JPG", taken),
+		mediaItem("id6", "IMG_6.JPG", taken),
+		mediaItem("id7", "IMG_7.JPG", taken),
+		mediaItem("id7b", "IMG_7.JPG", taken.Add(2*time.Minute)),
+	}
+
+	store := storage.NewMemory()
+	im := &Importer{
+		Store:  store,
+		Search: func(time.Time) ([]*photoslibrary.MediaItem, error) { return library, nil },
+		Logger: zap.NewNop().Sugar(),
+	}
+	result := &Result{}
+	archives := []string{writeZipFiles(t, "takeout-001.zip", part1), writeZipFiles(t, "takeout-002.zip", part2)}
+	if err := im.Import(archives, result); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if result.Imported != 6 || len(result.Unmatched) != 0 {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+
+	want := map[string]string{
+		backup.DestPath(library[0]):                        "five",
+		withSuffix(backup.DestPath(library[0]), "-edited"): "five edited",
+		backup.DestPath(library[1]):                        "six",
+		withSuffix(backup.DestPath(library[1]), "(1)"):     "six again",
+		backup.DestPath(library[2]):                        "seven",
+		backup.DestPath(library[3]):                        "seven b",
+	}
+	for name, data := range want {
+		if got, err := store.ReadFile(name); err != nil || string(got) != data {
+			t.Errorf("expected %v to hold %q, got: %q, %v", name, data, got, err)
+		}
+	}
+}
+
+func metadataJSON(title string, taken time.Time) string {
+	return fmt.Sprintf(`{"title": %q, "photoTakenTime": {"timestamp": "%v"}}`, title, taken.Unix())
+}
+
+func mediaItem(id, filename string, created time.Time) *photoslibrary.MediaItem {
+	return &photoslibrary.MediaItem{
+		Id:       id,
+		Filename: filename,
+		MediaMetadata: &photoslibrary.MediaMetadata{
+			CreationTime: created.Format(time.RFC3339),
+		},
+	}
+}
+
+func writeZip(t *testing.T) string {
+	return writeZipFiles(t, "takeout.zip", files)
+}
+
+func writeZipFiles(t *testing.T, name string, files []struct{ name, data string }) string {
+	fp := filepath.Join(t.TempDir(), name)
+	f, err := os.Create(fp)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer f.Close()
+	zw := zip.NewWriter(f)
+	for _, file := range files {
+		w, _ := zw.Create(file.name)
+		_, _ = w.Write([]byte(file.data))
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zip: %v", err)
+	}
+	return fp
+}
+
+func writeTgz(t *testing.T) string {
+	fp := filepath.Join(t.TempDir(), "takeout.tgz")
+	f, err := os.Create(fp)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, file := range files {
+		_ = tw.WriteHeader(&tar.Header{Name: file.name, Size: int64(len(file.data)), Mode: 0644, Typeflag: tar.TypeReg})
+		_, _ = tw.Write([]byte(file.data))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("tar: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	return fp
+}
+
+func TestImportObfuscated(t *testing.T) {
+	store := storage.NewMemory()
+	im := &Importer{
+		Store:     store,
+		Search:    func(time.Time) ([]*photoslibrary.MediaItem, error) { return items, nil },
+		Logger:    zap.NewNop().Sugar(),
+		Obfuscate: true,
+	}
+	result := &Result{}
+	if err := im.Import([]string{writeZip(t)}, result); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	dest := backup.ObfuscatedDestPath(items[0])
+	if got, err := store.ReadFile(dest); err != nil || string(got) != "one" {
+		t.Fatalf("expected %v to hold %q, got: %q, %v", dest, "one", got, err)
+	}
+	if filepath.Base(dest) != "id1" {
+		t.Errorf("obfuscated name = %v, want the item ID", dest)
+	}
+	if _, err := store.Stat(dest + backup.SidecarSuffix); err != nil {
+		t.Errorf("expected a sidecar for %v: %v", dest, err)
+	}
+}
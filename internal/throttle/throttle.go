@@ -0,0 +1,174 @@
+package throttle
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/ttomsu/gphotobackup/internal/utils"
+	"golang.org/x/time/rate"
+)
+
+// Unlimited is the rate that imposes no limit.
+const Unlimited = 0
+
+// minBurst keeps reads from being split into tiny pieces at low rates.
+const minBurst = 32 << 10
+
+// Window applies Rate (bytes per second, or Unlimited) between Start and
+// End, which are offsets from midnight. A window whose End is before its
+// Start runs past midnight.
+type Window struct {
+	Start, End time.Duration
+	Rate       int64
+}
+
+func (w Window) contains(t time.Duration) bool {
+	if w.Start <= w.End {
+		return t >= w.Start && t < w.End
+	}
+	return t >= w.Start || t < w.End
+}
+
+// Limiter is a token bucket of bytes shared by all the readers it wraps.
+// Its rate follows a daily schedule, falling back to a default rate.
+type Limiter struct {
+	lim      *rate.Limiter
+	def      int64
+	schedule []Window
+}
+
+// NewLimiter limits to def bytes per second outside the schedule's windows.
+func NewLimiter(def int64, schedule []Window) *Limiter {
+	l := &Limiter{lim: rate.NewLimiter(rate.Inf, minBurst), def: def, schedule: schedule}
+	l.apply(time.Now())
+	return l
+}
+
+// RateAt returns the bytes per second allowed at t, or Unlimited.
+func (l *Limiter) RateAt(t time.Time) int64 {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	sinceMidnight := t.Sub(midnight)
+	for _, w := range l.schedule {
+		if w.contains(sinceMidnight) {
+			return w.Rate
+		}
+	}
+	return l.def
+}
+
+func (l *Limiter) apply(t time.Time) {
+	r := l.RateAt(t)
+	if r == Unlimited {
+		l.lim.SetLimit(rate.Inf)
+		return
+	}
+	l.lim.SetBurst(max(int(r), minBurst))
+	l.lim.SetLimit(rate.Limit(r))
+}
+
+// Run updates the rate as the schedule moves between windows, until ctx is
+// done.
+func (l *Limiter) Run(ctx context.Context) {
+	if len(l.schedule) == 0 {
+		return
+	}
+	ticker := time.NewTicker(15 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case t := <-ticker.C:
+			l.apply(t)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Reader limits reads from r. Reads wait for their bytes to be available.
+func (l *Limiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	return &reader{ctx: ctx, r: r, l: l.lim}
+}
+
+type reader struct {
+	ctx context.Context
+	r   io.Reader
+	l   *rate.Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if r.l.Limit() != rate.Inf {
+		if burst := r.l.Burst(); len(p) > burst {
+			p = p[:burst]
+		}
+	}
+	n, err := r.r.Read(p)
+	if n > 0 && r.l.Limit() != rate.Inf {
+		if werr := r.l.WaitN(r.ctx, min(n, r.l.Burst())); werr != nil && err == nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+// ParseRate parses a rate such as "2MB/s", "500KiB" or "unlimited" into
+// bytes per second.
+func ParseRate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.EqualFold(s, "unlimited") || s == "0" {
+		return Unlimited, nil
+	}
+	n, err := utils.ParseBytes(strings.TrimSuffix(strings.TrimSuffix(s, "/s"), "ps"))
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid rate %q", s)
+	}
+	if n <= 0 {
+		return 0, errors.Errorf("invalid rate %q", s)
+	}
+	return n, nil
+}
+
+// ParseSchedule parses comma-separated windows such as
+// "00:00-07:00=unlimited, 07:00-24:00=2MB/s".
+func ParseSchedule(s string) ([]Window, error) {
+	var windows []Window
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		span, rateStr, ok := strings.Cut(part, "=")
+		startStr, endStr, ok2 := strings.Cut(span, "-")
+		if !ok || !ok2 {
+			return nil, errors.Errorf("invalid schedule entry %q, want HH:MM-HH:MM=rate", part)
+		}
+		start, err := parseClock(startStr)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseClock(endStr)
+		if err != nil {
+			return nil, err
+		}
+		r, err := ParseRate(rateStr)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, Window{Start: start, End: end, Rate: r})
+	}
+	return windows, nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	h, m, ok := strings.Cut(strings.TrimSpace(s), ":")
+	hours, herr := strconv.Atoi(h)
+	mins, merr := strconv.Atoi(m)
+	if !ok || herr != nil || merr != nil || hours < 0 || mins < 0 || mins > 59 || hours*60+mins > 24*60 {
+		return 0, fmt.Errorf("invalid time %q, want HH:MM", s)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(mins)*time.Minute, nil
+}
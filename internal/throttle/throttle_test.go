@@ -0,0 +1,67 @@
+package throttle
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	windows, err := ParseSchedule("00:00-07:00=unlimited, 07:00-24:00=2MB/s, 22:30-01:00=100KiB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) != 3 || windows[1].Rate != 2<<20 || windows[1].End != 24*time.Hour {
+		t.Fatalf("unexpected windows: %+v", windows)
+	}
+
+	l := NewLimiter(5<<20, windows[1:])
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.Local)
+	for _, tc := range []struct {
+		at   time.Duration
+		want int64
+	}{
+		{6 * time.Hour, 5 << 20},
+		{7 * time.Hour, 2 << 20},
+		{23*time.Hour + 59*time.Minute, 2 << 20},
+		{30 * time.Minute, 100 << 10},
+		{time.Hour, 5 << 20},
+	} {
+		if got := l.RateAt(day.Add(tc.at)); got != tc.want {
+			t.Errorf("RateAt(%v) = %v, want %v", tc.at, got, tc.want)
+		}
+	}
+
+	for _, bad := range []string{"07:00=1MB", "7-8=1MB", "07:00-25:00=1MB", "07:00-08:00=fast"} {
+		if _, err := ParseSchedule(bad); err == nil {
+			t.Errorf("expected %q to fail", bad)
+		}
+	}
+}
+
+func TestReader(t *testing.T) {
+	const rate = 256 << 10
+	l := NewLimiter(rate, nil)
+	data := make([]byte, rate+rate/2)
+
+	start := time.Now()
+	n, err := io.Copy(io.Discard, l.Reader(context.Background(), bytes.NewReader(data)))
+	if err != nil || n != int64(len(data)) {
+		t.Fatalf("copied %v, %v", n, err)
+	}
+	// The first second's worth is the burst; the remaining half second waits.
+	if elapsed := time.Since(start); elapsed < 400*time.Millisecond {
+		t.Errorf("read %v bytes at %v/s in only %v", len(data), rate, elapsed)
+	}
+
+	unlimited := NewLimiter(Unlimited, nil)
+	start = time.Now()
+	if _, err := io.Copy(io.Discard, unlimited.Reader(context.Background(), bytes.NewReader(make([]byte, 10<<20)))); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Errorf("unlimited read took %v", elapsed)
+	}
+}
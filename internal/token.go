@@ -0,0 +1,43 @@
+package internal
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/oauth2"
+)
+
+// ErrLoginRequired is returned when the saved refresh token has expired or
+// been revoked.
+var ErrLoginRequired = errors.New("saved credentials are no longer valid, re-run 'gphotobackup login'")
+
+// persistingTokenSource saves tokens to the secret store whenever they are
+// refreshed, so a rotated refresh token isn't lost.
+type persistingTokenSource struct {
+	src   oauth2.TokenSource
+	store SecretStore
+	mu    sync.Mutex
+	last  *oauth2.Token
+}
+
+func (p *persistingTokenSource) Token() (*oauth2.Token, error) {
+	token, err := p.src.Token()
+	if err != nil {
+		var re *oauth2.RetrieveError
+		if errors.As(err, &re) && re.ErrorCode == "invalid_grant" {
+			return nil, errors.Wrap(ErrLoginRequired, re.ErrorDescription)
+		}
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.last != nil && token.AccessToken == p.last.AccessToken && token.RefreshToken == p.last.RefreshToken {
+		return token, nil
+	}
+	if err := saveToken(p.store, token); err != nil {
+		return nil, errors.Wrap(err, "saving refreshed token")
+	}
+	p.last = token
+	return token, nil
+}
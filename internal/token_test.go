@@ -0,0 +1,53 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestPersistingTokenSource(t *testing.T) {
+	tempConfigDir(t)
+	grant := "valid"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if grant != "valid" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"error": "invalid_grant", "error_description": "Token has been expired or revoked."}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"access_token": "new-access", "refresh_token": "rotated-refresh", "expires_in": 3600}`))
+	}))
+	defer ts.Close()
+
+	cfg := &oauth2.Config{Endpoint: oauth2.Endpoint{TokenURL: ts.URL}}
+	expired := &oauth2.Token{AccessToken: "old-access", RefreshToken: "refresh", Expiry: time.Now().Add(-time.Hour)}
+	src := &persistingTokenSource{src: cfg.TokenSource(context.Background(), expired), store: plainStore{}, last: expired}
+
+	if _, err := src.Token(); err != nil {
+		t.Fatalf("token: %v", err)
+	}
+	data, err := ReadFromConfigDir(TokenFilename)
+	if err != nil {
+		t.Fatalf("reading saved token: %v", err)
+	}
+	saved := &oauth2.Token{}
+	if err := json.Unmarshal(data, saved); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if saved.AccessToken != "new-access" || saved.RefreshToken != "rotated-refresh" {
+		t.Fatalf("expected refreshed token to be saved, got: %+v", saved)
+	}
+
+	grant = "revoked"
+	src = &persistingTokenSource{src: cfg.TokenSource(context.Background(), expired), store: plainStore{}, last: expired}
+	if _, err := src.Token(); !errors.Is(err, ErrLoginRequired) {
+		t.Fatalf("expected ErrLoginRequired, got: %v", err)
+	}
+}
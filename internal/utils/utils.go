@@ -1,6 +1,12 @@
 package utils
 
-import "regexp"
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
 
 var (
 	specialChars = regexp.MustCompile(`\W`)
@@ -9,3 +15,37 @@ var (
 func Sanitize(t string) string {
 	return specialChars.ReplaceAllString(t, "_")
 }
+
+// FormatBytes returns n as a human readable size, e.g. "1.5 GiB".
+func FormatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// ParseBytes parses a size such as "650M", "4.7GB" or "1GiB" into bytes. Units
+// are powers of 1024, and a plain number is bytes.
+func ParseBytes(s string) (int64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	num := strings.TrimRight(s, "KMGTPEIB")
+	unit := strings.TrimSuffix(strings.TrimSuffix(s[len(num):], "B"), "I")
+	f, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	if unit == "" {
+		return int64(f), nil
+	}
+	exp := strings.Index("KMGTPE", unit)
+	if exp < 0 || len(unit) != 1 {
+		return 0, fmt.Errorf("invalid size unit in %q", s)
+	}
+	return int64(f * math.Pow(1024, float64(exp+1))), nil
+}
@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParseBytes(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int64
+	}{
+		{input: "100", want: 100},
+		{input: "650M", want: 650 << 20},
+		{input: "1GiB", want: 1 << 30},
+		{input: "1.5kb", want: 1536},
+	}
+
+	for i, tc := range tests {
+		t.Run(fmt.Sprintf("%v", i), func(t *testing.T) {
+			got, err := ParseBytes(tc.input)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tc.want {
+				t.Fatalf("expected: %v, got: %v", tc.want, got)
+			}
+		})
+	}
+
+	if _, err := ParseBytes("12X"); err == nil {
+		t.Fatalf("expected error for unknown unit")
+	}
+}